@@ -2,20 +2,24 @@ package core
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"errors"
+	"io"
 	"log"
 	"os"
 	"regexp"
-	"strconv"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
 	"github.com/kookehs/kneissbot/net/api/twitch"
 	"github.com/kookehs/kneissbot/net/irc"
+	"github.com/kookehs/kneissbot/net/irc/tmi"
 	"github.com/kookehs/kneissbot/net/server"
-	"github.com/kookehs/watchmen/primitives"
+	"github.com/kookehs/kneissbot/time/clock"
+	"golang.org/x/oauth2"
 )
 
 // TODO: Blacklist of users (never moderator)
@@ -27,8 +31,6 @@ const (
 	BalanceFormat = `!balance ((?:\w+ ?)*)`
 	// CommandFormat defines the search pattern for a command.
 	CommandFormat = `!(\w+)`
-	// ModeratorFormat defines the search pattern for a list of moderators.
-	ModeratorFormat = `The moderators of this channel are: ((?:\w+(?:, )?)+)`
 	// SendFormat defines the search pattern for sending funds to another user.
 	SendFormat = `!send (\w+) (\d+)`
 	// VoteFormat defines the search pattern for voting for delegates.
@@ -40,18 +42,31 @@ var (
 	BalanceRegExp = regexp.MustCompile(BalanceFormat)
 	// CommandRegExp is the regular expression used to find commands.
 	CommandRegExp = regexp.MustCompile(CommandFormat)
-	// ModeratorRegExp is the regular expression used to find moderators.
-	ModeratorRegExp = regexp.MustCompile(ModeratorFormat)
 	// SendRegExp is the regular expression used to find the receiver.
 	SendRegExp = regexp.MustCompile(SendFormat)
 	// VoteRegExp is the regular expression used to find the delegates elected.
 	VoteRegExp = regexp.MustCompile(VoteFormat)
 
+	// APIOptions are applied to the Twitch API of every Bot, such as to
+	// point it at a fake Twitch API.
+	APIOptions []twitch.Option
+	// IRCTransport replaces the transport selected by the configuration of
+	// every Bot, such as to connect to a fake IRC server.
+	IRCTransport irc.Transport
 	// BotCommands is a mapping of strings to functions related to the bot.
-	BotCommands = make(map[string]func(*Bot, irc.Message))
-	// TwitchCommands is a mapping of strings to functions related to IRC.
-	TwitchCommands = make(map[string]func(*Bot, irc.Message))
+	BotCommands = make(map[string]func(*Channel, *tmi.PrivMsg))
+	// OfflineUpdates enables the heuristic and elections while streams are offline.
+	OfflineUpdates = false
 
+	// defaultCapabilities are negotiated by the reader if the bot does not
+	// request any.
+	defaultCapabilities = []string{
+		twitch.CommandsCapability,
+		twitch.MembershipCapability,
+		twitch.TagsCapability,
+	}
+	// ReplyTimeout is the time to wait for the IRC server to reply to a request.
+	ReplyTimeout = 10 * time.Second
 	// UpdateInterval is the time in seconds for an update to trigger.
 	UpdateInterval time.Duration = 60
 )
@@ -62,36 +77,40 @@ func init() {
 	BotCommands["register"] = Register
 	BotCommands["send"] = Send
 	BotCommands["vote"] = Vote
-	TwitchCommands["CLEARCHAT"] = ClearChat
-	TwitchCommands[irc.RPL_ENDOFMOTD] = EndOfMOTD
-	TwitchCommands[irc.RPL_ENDOFNAMES] = EndOfNames
-	TwitchCommands["NOTICE"] = Notice
-	TwitchCommands["PING"] = Ping
-	TwitchCommands["PRIVMSG"] = PrivMSG
 
 	// Twitch has a 500 character limit, not including line endings, not a 512 byte limit.
 	irc.MaxMessageSize = 512 * utf8.UTFMax
 }
 
 // Bot contains logic realted to both the API and IRC.
+// Every joined channel is managed separately and stored in a directory
+// of its own name. Session is the reader of the Pool and receives chat
+// while outgoing messages are written through the Pool. Moderation and
+// stream events of joined channels are received from EventSub.
 type Bot struct {
-	API        *twitch.API
-	Config     *Config
-	Event      chan irc.Message
-	Management *Management
-	Session    *irc.Session
-	Timer      *time.Timer
+	API          *twitch.API
+	Capabilities []string
+	Channels     map[string]*Channel
+	Clock        clock.Clock
+	Config       *Config
+	Directory    string
+	EventSub     *twitch.EventSub
+	Events       *tmi.Dispatcher
+	Pool         *irc.Pool
+	Replies      *irc.Correlator
+	Session      *irc.Session
+	Timer        clock.Timer
+
+	cancel  context.CancelFunc
+	enabled map[string]bool
+	mutex   sync.Mutex
+	writers []*writer
 }
 
 // NewBot returns a pointer to an initialized Bot struct.
 func NewBot() (*Bot, error) {
 	bot := new(Bot)
-	session, err := irc.NewSession(twitch.Origin, twitch.IRC)
-
-	if err != nil {
-		return nil, err
-	}
-
+	bot.Clock = clock.Real{}
 	bot.Config = NewConfig()
 	home, err := Home()
 
@@ -110,96 +129,185 @@ func NewBot() (*Bot, error) {
 	}
 
 	bot.Config.Files["config"] = path + "/config.bin"
-	bot.Config.Files["ledger"] = path + "/ledger.bin"
-	bot.Config.Files["ma"] = path + "/ma.bin"
-
-	bot.Event = make(chan irc.Message)
-	bot.Management = NewManagement(bot, bot.Config.Twitch.Username)
-	bot.Session = session
-	bot.Timer = time.NewTimer(UpdateInterval * time.Second)
+	bot.Channels = make(map[string]*Channel)
+	bot.Directory = path
+	bot.Events = tmi.NewDispatcher()
+	bot.Replies = irc.NewCorrelator()
+	bot.Subscribe(bot.Events)
 
 	if _, err := os.Stat(bot.Config.Files["config"]); os.IsNotExist(err) {
-		output := make(chan string)
-		defer close(output)
-		twitchAuth := server.NewTwitchAuth(output)
-		go twitchAuth.ListenAndServe()
+		token, err := authenticate()
 
-		if err := twitchAuth.Authenticate(); err != nil {
+		if err != nil {
 			panic(err)
 		}
 
-		defer twitchAuth.Close()
-		bot.Config.Twitch.AccessToken = <-output
+		bot.Config.Twitch.SetToken(token)
 	} else {
 		bot.Deserialize()
-		log.Println(bot.Config.Twitch.AccessToken)
 	}
 
-	bot.API = twitch.NewAPI(bot.Config.Twitch.AccessToken)
-	response, err := bot.API.ValidToken()
+	transport := IRCTransport
 
-	if err != nil || !response.Token.Valid {
-		if !response.Token.Valid {
-			return nil, errors.New("Invalid token")
+	if transport == nil {
+		transport, err = NewTransport(bot.Config.Twitch.Transport)
+
+		if err != nil {
+			return nil, err
 		}
+	}
+
+	pool, err := irc.NewPool(transport, bot.Config.Twitch.Writers)
+
+	if err != nil {
+		return nil, err
+	}
+
+	bot.Pool = pool
+	bot.Session = pool.Reader
+	bot.API = twitch.NewAPI(bot.Config.Twitch.AccessToken, APIOptions...)
+	bot.API.ClientID = server.ClientID
+	bot.API.OAuth = server.OAuth2Config()
+	bot.API.SetToken(bot.Config.Twitch.Token())
+	bot.API.Handler = startup{bot}
+	response, err := bot.API.Validate(context.Background())
 
+	if err != nil {
+		pool.Close()
 		return nil, err
 	}
 
-	bot.Config.Twitch.Username = response.Token.Username
+	bot.API.Handler = bot
+	bot.Config.Twitch.Username = response.Login
+	bot.EventSub = twitch.NewEventSub(bot.API)
+	bot.SubscribeEventSub(bot.EventSub)
 	return bot, nil
 }
 
-// Balance returns the user's balance or a set of users.
-func Balance(bot *Bot, message irc.Message) {
-	username := message.Prefix.User
-	users := make([]string, 0)
+// NewReplayBot returns a pointer to a Bot for replaying a recorded session
+// of the given channels without a network connection. State is stored in
+// dir instead of the home directory and time is driven by a fake clock.
+// The first channel is used as the bot's username.
+func NewReplayBot(channels []string, dir string) (*Bot, error) {
+	bot := new(Bot)
+	bot.Clock = clock.NewFake(time.Time{})
+	bot.Config = NewConfig()
 
-	for _, param := range message.Params {
-		matches := BalanceRegExp.FindStringSubmatch(param)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
 
-		if matches == nil || len(matches) < 1 {
-			continue
-		}
+	if len(channels) > 0 {
+		bot.Config.Twitch.Username = channels[0]
+	}
 
-		users = strings.Fields(matches[1])
-		break
+	bot.Config.Files["config"] = dir + "/config.bin"
+	bot.Channels = make(map[string]*Channel)
+	bot.Directory = dir
+	bot.Events = tmi.NewDispatcher()
+	bot.Replies = irc.NewCorrelator()
+	bot.Subscribe(bot.Events)
+	pool, err := irc.NewPool(irc.DiscardTransport{}, 0)
+
+	if err != nil {
+		return nil, err
 	}
 
-	if len(users) == 0 {
-		users = append(users, username)
+	bot.Pool = pool
+	bot.Session = pool.Reader
+
+	for _, channel := range channels {
+		bot.addChannel(channel)
 	}
 
-	// Respond with a whisper to the user who requested the balance(s).
-	buffer := bytes.NewBufferString("/w ")
-	buffer.WriteString(username)
-	buffer.WriteByte(' ')
+	return bot, nil
+}
 
-	for _, user := range users {
-		iban := bot.Management.Ledger.Users[user]
+// authenticate redirects the user to Twitch's authorization page and
+// blocks until a token is retrieved or the authorization failed. Without
+// a browser, such as on a headless server, the device authorization flow
+// is used instead.
+func authenticate() (*oauth2.Token, error) {
+	output := make(chan *oauth2.Token)
+	twitchAuth := server.NewTwitchAuth(output)
+	defer twitchAuth.Close()
+	go twitchAuth.ListenAndServe()
+
+	if err := twitchAuth.Authenticate(); err != nil {
+		log.Println("[Bot]: Unable to open browser, falling back to device authorization - " + err.Error())
+		return server.NewDeviceAuth(os.Stderr).Authenticate()
+	}
+
+	select {
+	case token := <-output:
+		return token, nil
+	case err := <-twitchAuth.Errors:
+		return nil, err
+	}
+}
 
-		if block := bot.Management.Ledger.LatestBlock(iban); block != nil {
-			balance := block.Balance()
-			buffer.WriteString(user)
-			buffer.WriteByte('(')
-			buffer.WriteString(balance.Text('f', -1))
-			buffer.WriteString(") ")
-		}
+// Authorize retrieves a new token and stores it in the configuration in
+// the home directory without starting the bot. The device authorization
+// flow is used if device is set, otherwise the user's browser is opened.
+func Authorize(device bool) error {
+	bot := new(Bot)
+	bot.Config = NewConfig()
+	home, err := Home()
+
+	if err != nil {
+		return err
+	}
+
+	path := home + "/.kneissbot"
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
 	}
 
-	bot.PrivMSG(buffer.String())
+	bot.Config.Files["config"] = path + "/config.bin"
+	bot.Deserialize()
+	var token *oauth2.Token
+
+	if device {
+		token, err = server.NewDeviceAuth(os.Stdout).Authenticate()
+	} else {
+		token, err = authenticate()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	bot.Config.Twitch.SetToken(token)
+	bot.serializeConfig()
+	return nil
+}
+
+// CapReply is the handler for CAP replies which were not requested by
+// Cap, such as those in a replayed session.
+func CapReply(bot *Bot, message irc.Message) {
+	if len(message.Params) > 2 {
+		bot.capReply(message.Params[1], strings.Fields(message.Params[2]))
+	}
 }
 
 // ClearChat is the handler for the CLEARCHAT command sent from IRC.
-func ClearChat(bot *Bot, message irc.Message) {
-	if strings.Compare(message.Tags["ban-duration"], "") == 0 {
-		bot.Management.Bans++
-	} else {
-		bot.Management.Timeouts++
+// Clearing the whole chat is neither a ban nor a timeout. Without the tags
+// capability bans and timeouts are indistinguishable and are counted as
+// timeouts. Bans are only inferred while channel.ban events are not
+// received from EventSub.
+func ClearChat(bot *Bot, event *tmi.ClearChat) {
+	channel := bot.Channel(event.Channel)
+
+	if channel == nil || len(event.Login) == 0 || channel.Subscribed(twitch.ChannelBan) {
+		return
 	}
+
+	channel.Management.Ban("", bot.Enabled(twitch.TagsCapability) && event.Permanent())
 }
 
-// Deserialize retrieves state information of the bot from disk.
+// Deserialize retrieves the configuration of the bot from disk.
+// Channels retrieve their own state when joined.
 func (b *Bot) Deserialize() {
 	configPath := b.Config.Files["config"]
 	configFile, err := os.OpenFile(configPath, os.O_RDONLY, 0666)
@@ -209,366 +317,647 @@ func (b *Bot) Deserialize() {
 		reader := bufio.NewReader(configFile)
 		b.Config.Deserialize(reader)
 	}
+}
 
-	ledgerPath := b.Config.Files["ledger"]
-	ledgerFile, err := os.OpenFile(ledgerPath, os.O_RDONLY, 0666)
-
-	if err == nil {
-		defer ledgerFile.Close()
-		reader := bufio.NewReader(ledgerFile)
-		b.Management.Ledger.Deserialize(reader)
-	}
+// Notice is the handler for the NOTICE command sent from IRC.
+// Replies to requests are consumed before reaching handlers, so any
+// NOTICE arriving here was unsolicited.
+func Notice(bot *Bot, event *tmi.Notice) {
+	log.Printf("[Bot]: Notice %v - %v", event.MsgID, event.Text)
+}
 
-	maPath := b.Config.Files["ma"]
-	maFile, err := os.OpenFile(maPath, os.O_RDONLY, 0666)
+// Ping is the handler for the PING command sent from IRC.
+func Ping(bot *Bot, message irc.Message) {
+	bot.Session.Write("PONG :tmi.twitch.tv")
+}
 
-	if err == nil {
-		defer maFile.Close()
-		reader := bufio.NewReader(maFile)
-		b.Management.MovingAverage.Deserialize(reader)
+// Join is the handler for the JOIN command sent from IRC with the
+// membership capability.
+func Join(bot *Bot, event *tmi.Join) {
+	if channel := bot.Channel(event.Channel); channel != nil {
+		channel.Presence.Join(event.Login, bot.Clock.Now())
 	}
 }
 
-// EndOfMOTD is the handler for the ENDOFMOTD command sent from IRC.
-func EndOfMOTD(bot *Bot, message irc.Message) {
-	bot.Event <- message
+// Part is the handler for the PART command sent from IRC with the
+// membership capability.
+func Part(bot *Bot, event *tmi.Part) {
+	if channel := bot.Channel(event.Channel); channel != nil {
+		channel.Presence.Part(event.Login, bot.Clock.Now())
+	}
 }
 
-// EndOfNames is the handler for the ENDOFNAMES command sent from IRC.
-func EndOfNames(bot *Bot, message irc.Message) {
-	bot.Event <- message
+// PrivMSG is the handler for the PRIVMSG command sent from IRC.
+// Messages are routed to the channel they were sent to.
+func PrivMSG(bot *Bot, event *tmi.PrivMsg) {
+	channel := bot.Channel(event.Channel)
+
+	if channel == nil {
+		return
+	}
+
+	channel.Management.Message()
+	channel.Presence.Join(event.Login, bot.Clock.Now())
+	channel.ParseCommand(event)
 }
 
-// Notice is the handler for the NOTICE command sent from IRC.
-func Notice(bot *Bot, message irc.Message) {
-	bot.Event <- message
+// Reconnect is the handler for the RECONNECT command sent from IRC.
+// Twitch sends RECONNECT before terminating the connection for maintenance.
+func Reconnect(bot *Bot, event *tmi.Reconnect) {
+	if err := bot.Session.Reconnect(); err != nil {
+		log.Println(err)
+	}
 }
 
-// Ping is the handler for the PING command sent from IRC.
-func Ping(bot *Bot, message irc.Message) {
-	bot.Session.Write("PONG :tmi.twitch.tv")
+// UserState is the handler for the USERSTATE command sent from IRC.
+// The rate limit of messages to the channel follows the bot's moderator
+// status in it.
+func UserState(bot *Bot, event *tmi.UserState) {
+	bot.Pool.SetModerator(event.Channel, event.Moderator || event.Broadcaster())
 }
 
-// PrivMSG is the handler for the PRIVMSG command sent from IRC.
-func PrivMSG(bot *Bot, message irc.Message) {
-	bot.Management.Messages++
-	bot.ParseCommand(message)
+// Available returns whether or not the given user is in the given channel.
+func (b *Bot) Available(channel, username string) bool {
+	if c := b.Channel(channel); c != nil {
+		return c.Available(username)
+	}
+
+	return false
 }
 
-// Register creates an account for the given user in the ledger.
-func Register(bot *Bot, message irc.Message) {
-	username := message.Prefix.User
+// Cap negotiates the given capabilities with the IRC server over the
+// reader session. Default capabilities are used if none are given. The
+// blocking operation returns an error if the server does not reply in
+// time. Whether a capability was acknowledged is reported by Enabled.
+// Connect already negotiates the capabilities of the bot, so Cap is only
+// needed to change them.
+func (b *Bot) Cap(capabilities []string) error {
+	if len(capabilities) == 0 {
+		capabilities = defaultCapabilities
+	}
 
-	if _, ok := bot.Management.Ledger.Users[username]; !ok {
-		bot.Management.Ledger.OpenAccount(bot.Management.Node, username)
+	b.mutex.Lock()
+	b.Capabilities = capabilities
+	b.mutex.Unlock()
+	waiter := b.Replies.Expect(capFilter("LS", ""))
+	b.Session.Write("CAP LS 302")
+	enabled, err := negotiate(b.Session, waiter, b.Replies, capabilities)
+
+	if err != nil {
+		return err
 	}
+
+	b.mutex.Lock()
+	b.enabled = enabled
+	b.mutex.Unlock()
+	return nil
 }
 
-// Send sends tokens from one user to another.
-func Send(bot *Bot, message irc.Message) {
-	username := message.Prefix.User
+// negotiate requests the capabilities the IRC server lists in reply to
+// CAP LS over the session. The capabilities are requested one at a time
+// so that a NAK only rejects a single capability. Replies are awaited from
+// the given Correlator, starting with the listing of the waiter. The
+// blocking operation returns which capabilities were acknowledged or an
+// error if the server does not reply in time.
+func negotiate(session *irc.Session, waiter *irc.Waiter, replies *irc.Correlator, capabilities []string) (map[string]bool, error) {
+	message, err := waiter.Wait(ReplyTimeout)
 
-	for _, param := range message.Params {
-		matches := SendRegExp.FindStringSubmatch(param)
+	if err != nil {
+		return nil, err
+	}
 
-		if matches == nil || len(matches) < 2 {
+	available := make(map[string]bool)
+	enabled := make(map[string]bool)
+
+	// Version 302 lists capabilities along with their values.
+	for _, capability := range strings.Fields(message.Params[len(message.Params)-1]) {
+		available[strings.SplitN(capability, "=", 2)[0]] = true
+	}
+
+	for _, capability := range capabilities {
+		if !available[capability] {
+			log.Println("[Bot]: Capability not supported - " + capability)
+			enabled[capability] = false
 			continue
 		}
 
-		receiver := matches[1]
-		amount, err := strconv.Atoi(matches[2])
+		waiter := replies.Expect(irc.Any(capFilter("ACK", capability), capFilter("NAK", capability)))
+		session.Write("CAP REQ :" + capability)
+		message, err := waiter.Wait(ReplyTimeout)
 
 		if err != nil {
-			log.Println(err)
-			return
+			return nil, err
 		}
 
-		funds := primitives.NewAmount(float64(amount))
-		src := bot.Management.Ledger.Users[username]
-		dst := bot.Management.Ledger.Users[receiver]
-		_, err = bot.Management.Ledger.Transfer(funds, dst, src, bot.Management.Node)
+		enabled[capability] = strings.Compare(message.Params[1], "ACK") == 0
 
-		if err != nil {
-			log.Println(err)
-			return
+		if !enabled[capability] {
+			log.Println("[Bot]: Capability rejected - " + capability)
 		}
+	}
+
+	session.Write("CAP END")
+	return enabled, nil
+}
 
-		break
+// capFilter returns a Filter matching CAP replies of the given subcommand.
+// If capabilities is not empty, the reply must be about exactly those.
+func capFilter(subcommand, capabilities string) irc.Filter {
+	return func(m irc.Message) bool {
+		if strings.Compare(m.Command, "CAP") != 0 || len(m.Params) < 3 {
+			return false
+		}
+
+		if strings.Compare(m.Params[1], subcommand) != 0 {
+			return false
+		}
+
+		return len(capabilities) == 0 || strings.Compare(strings.TrimSpace(m.Params[2]), capabilities) == 0
 	}
 }
 
-// Vote handles a user's choice to add or remove delegates.
-func Vote(bot *Bot, message irc.Message) {
-	username := message.Prefix.User
+// capReply records whether the given capabilities were acknowledged.
+func (b *Bot) capReply(subcommand string, capabilities []string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 
-	for _, param := range message.Params {
-		matches := VoteRegExp.FindStringSubmatch(param)
+	if b.enabled == nil {
+		b.enabled = make(map[string]bool)
+	}
 
-		if matches == nil || len(matches) < 1 {
-			continue
+	for _, capability := range capabilities {
+		switch subcommand {
+		case "ACK":
+			b.enabled[capability] = true
+		case "NAK":
+			b.enabled[capability] = false
+			log.Println("[Bot]: Capability rejected - " + capability)
 		}
+	}
+}
 
-		delegates := strings.Fields(matches[1])
-		iban := bot.Management.Ledger.Users[username]
-		account := bot.Management.Ledger.Accounts[iban.String()]
+// Channel returns the joined channel of the given name or nil.
+func (b *Bot) Channel(name string) *Channel {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.Channels[name]
+}
 
-		if err := bot.Management.DPoS.Elect(account, delegates, bot.Management.Ledger, bot.Management.Node); err != nil {
-			log.Println(err)
-			continue
-		}
+// Close shuts down channels, EventSub and the underlying IRC connection.
+func (b *Bot) Close() error {
+	if b.cancel != nil {
+		b.cancel()
+	}
 
-		break
+	if err := b.Pool.Close(); err != nil {
+		return err
 	}
+
+	return nil
+}
+
+// Enabled returns whether the given capability was acknowledged by the IRC server.
+func (b *Bot) Enabled(capability string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.enabled[capability]
 }
 
-// Amend makes changes to the current set of moderators.
-func (b *Bot) Amend(moderators []string) {
-	// Retrieve current list of moderators.
-	b.PrivMSG("/mods")
-	message := <-b.Event
-	id, ok := message.Tags["msg-id"]
+// Expired is called once the access token is rejected by Twitch and could
+// not be refreshed. The user is asked to authorize again and the new token
+// is used for both the API and subsequent IRC connections.
+func (b *Bot) Expired() {
+	log.Println("[Bot]: Access token expired")
+	token, err := authenticate()
 
-	if !ok || strings.Compare(id, "room_mods") != 0 {
+	if err != nil {
+		log.Println(err)
 		return
 	}
 
-	// A mapping of users to mod or unmod.
-	amendment := make(map[string]bool)
-
-	for _, moderator := range moderators {
-		amendment[moderator] = true
-	}
+	b.API.SetToken(token)
+	b.Refreshed(token)
+}
 
-	for _, param := range message.Params {
-		matches := ModeratorRegExp.FindStringSubmatch(param)
+// startup handles the token of the API while NewBot validates it. Tokens
+// refreshed are stored, but a rejected token fails NewBot rather than
+// asking the user to authorize again.
+type startup struct {
+	*Bot
+}
 
-		if matches == nil || len(matches) < 1 {
-			continue
-		}
+// Expired ignores the rejected token as NewBot returns the error.
+func (startup) Expired() {}
 
-		mods := strings.Split(matches[1], ", ")
+// Refreshed stores the token refreshed by the API so that it is used for
+// subsequent IRC connections and survives a restart.
+func (b *Bot) Refreshed(token *oauth2.Token) {
+	b.mutex.Lock()
+	b.Config.Twitch.SetToken(token)
+	b.mutex.Unlock()
+	b.serializeConfig()
+}
 
-		for _, mod := range mods {
-			if _, exist := amendment[mod]; !exist {
-				amendment[mod] = false
-			}
-		}
+// Connect authenticates every session of the Pool with the IRC server.
+// Capabilities are listed before logging in and negotiated for every
+// session on its own, so that the writers do not change those of the bot.
+// Start must be called first so that replies are received. Writers failing
+// to connect are skipped until they reconnect.
+// The blocking operation returns whether connecting to IRC server was successful
+func (b *Bot) Connect() bool {
+	enabled, ok := b.connect(b.Session, b.Replies, b.capabilities())
 
-		break
+	if !ok {
+		return false
 	}
 
-	for moderator, operator := range amendment {
-		if operator {
-			b.PrivMSG("/mod " + moderator)
-		} else {
-			b.PrivMSG("/unmod " + moderator)
+	b.mutex.Lock()
+	b.enabled = enabled
+	writers := b.writers
+	b.mutex.Unlock()
+
+	for i, writer := range writers {
+		if !writer.connect() {
+			log.Printf("[Bot]: Unable to connect writer %v", i)
 		}
 	}
+
+	return true
 }
 
-// Available returns whether or not the given user is online.
-func (b *Bot) Available(username string) bool {
-	resp, err := b.API.GetChatters(b.Config.Twitch.Username)
+// connect authenticates the given session and negotiates the given
+// capabilities, awaiting the replies from the given Correlator. It returns
+// which capabilities were acknowledged.
+// The blocking operation returns whether connecting to IRC server was successful
+func (b *Bot) connect(session *irc.Session, replies *irc.Correlator, capabilities []string) (map[string]bool, bool) {
+	// Wait until we receive end of MOTD or a notice of failed authentication.
+	waiter := replies.Expect(irc.Commands(irc.RPL_ENDOFMOTD, "NOTICE"))
+	listed := replies.Expect(capFilter("LS", ""))
+	defer waiter.Cancel()
+	defer listed.Cancel()
+	token, username := b.credentials()
+	session.Write("CAP LS 302")
+	session.Write("PASS oauth:" + token)
+	session.Write("NICK " + username)
+	enabled, err := negotiate(session, listed, replies, capabilities)
 
 	if err != nil {
 		log.Println(err)
-		return false
+		return nil, false
 	}
 
-	for _, admin := range resp.Chatters.Admins {
-		if strings.Compare(username, admin) == 0 {
-			return true
-		}
-	}
+	message, err := waiter.Wait(ReplyTimeout)
 
-	for _, mod := range resp.Chatters.GlobalMods {
-		if strings.Compare(username, mod) == 0 {
-			return true
-		}
+	if err != nil {
+		log.Println(err)
+		return nil, false
 	}
 
-	for _, mod := range resp.Chatters.Moderators {
-		if strings.Compare(username, mod) == 0 {
-			return true
-		}
+	switch message.Command {
+	case irc.RPL_ENDOFMOTD:
+		return enabled, true
 	}
 
-	for _, staff := range resp.Chatters.Staff {
-		if strings.Compare(username, staff) == 0 {
-			return true
-		}
-	}
+	log.Println("[Bot]: " + message.String())
+	return nil, false
+}
 
-	for _, viewer := range resp.Chatters.Viewers {
-		if strings.Compare(username, viewer) == 0 {
-			return true
-		}
+// capabilities returns the capabilities requested by the bot.
+func (b *Bot) capabilities() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.Capabilities) == 0 {
+		return defaultCapabilities
 	}
 
-	return false
+	return append([]string(nil), b.Capabilities...)
 }
 
-// Cap sends a request for the given capabilities.
-// Default capabilities are used if none are given.
-func (b *Bot) Cap(capabilities []string) {
-	caps := []string{
-		twitch.CommandsCapability,
-		twitch.MembershipCapability,
-		twitch.TagsCapability,
-	}
+// credentials returns the access token and username to log into IRC with.
+// The token is refreshed concurrently by the API.
+func (b *Bot) credentials() (string, string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.Config.Twitch.AccessToken, b.Config.Twitch.Username
+}
 
-	if capabilities != nil && len(capabilities) > 0 {
-		caps = capabilities
+// In handles all incoming messages.
+func (b *Bot) In(input []byte) {
+	message, err := irc.MakeMessage(string(input))
+
+	if err != nil {
+		log.Println(err)
+		return
 	}
 
-	for _, cap := range caps {
-		b.Session.Write("CAP REQ :" + cap)
+	if b.Replies.Offer(message) {
+		return
 	}
+
+	b.Events.Dispatch(message)
 }
 
-// Close shuts down channels and the underlying websocket connection.
-func (b *Bot) Close() error {
-	close(b.Event)
+// Join sends a request to join the given channel. The state of the
+// channel is loaded from disk and the channel is remembered so that it is
+// joined again after reconnecting.
+// The blocking operation returns whether joining the channel was successful
+func (b *Bot) Join(channel string) bool {
+	c := b.addChannel(channel)
 
-	if err := b.Session.Close(); err != nil {
-		return err
+	if ok := b.join(channel); !ok {
+		return false
 	}
 
-	return nil
-}
-
-// Connect sends the given credentials to the IRC server for authentication.
-// The blocking operation returns whether connecting to IRC server was successful
-func (b *Bot) Connect() bool {
-	b.Session.Write("PASS oauth:" + b.Config.Twitch.AccessToken)
-	b.Session.Write("NICK " + b.Config.Twitch.Username)
+	// Retrieving the chatters of a big channel takes several requests.
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), UpdateInterval*time.Second)
+		defer cancel()
 
-	// Wait until we receive end of MOTD.
-	message := <-b.Event
+		if err := c.Refresh(ctx); err != nil {
+			log.Println(err)
+		}
 
-	switch message.Command {
-	case irc.RPL_ENDOFMOTD:
-		return true
-	}
+		if err := c.Subscribe(ctx); err != nil {
+			log.Println(err)
+		}
+	}()
 
-	return false
+	return true
 }
 
-// In handles all incoming messages.
-func (b *Bot) In(input []byte) {
-	message := irc.MakeMessage(string(input))
+// addChannel creates the Channel of the given name unless it exists.
+// The bot's own channel falls back to the state stored before channels
+// had directories of their own.
+func (b *Bot) addChannel(name string) *Channel {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if channel, ok := b.Channels[name]; ok {
+		return channel
+	}
 
-	if callback, ok := TwitchCommands[message.Command]; ok {
-		callback(b, message)
+	channel := NewChannel(b, name, b.Directory+"/"+name)
+
+	if strings.Compare(name, b.Config.Twitch.Username) == 0 {
+		if _, err := os.Stat(channel.Files["ledger"]); os.IsNotExist(err) {
+			if _, err := os.Stat(b.Directory + "/ledger.bin"); err == nil {
+				channel.Files["ledger"] = b.Directory + "/ledger.bin"
+				channel.Files["ma"] = b.Directory + "/ma.bin"
+			}
+		}
 	}
+
+	channel.Deserialize()
+	b.Channels[name] = channel
+	return channel
 }
 
-// Join sends a request to join the given channel.
-// The blocking operation returns whether joining the channel was successful
-func (b *Bot) Join(channel string) bool {
+// join sends a request to join the given channel and waits for the reply.
+func (b *Bot) join(channel string) bool {
+	// Wait until we receive end of names or a notice of why joining failed.
+	waiter := b.Replies.Expect(irc.Any(
+		func(m irc.Message) bool {
+			return strings.Compare(m.Command, irc.RPL_ENDOFNAMES) == 0 &&
+				len(m.Params) > 1 && strings.Compare(m.Params[1], "#"+channel) == 0
+		},
+		irc.MsgIDs("msg_channel_suspended", "msg_banned"),
+	))
 	b.Session.Write("JOIN #" + channel)
+	message, err := waiter.Wait(ReplyTimeout)
 
-	// Wait until we receive end of names.
-	message := <-b.Event
+	if err != nil {
+		log.Println(err)
+		return false
+	}
 
 	switch message.Command {
 	case irc.RPL_ENDOFNAMES:
 		return true
 	}
 
+	log.Println("[Bot]: " + message.String())
 	return false
 }
 
-// ParseCommand parses commands related to the bot.
-func (b *Bot) ParseCommand(message irc.Message) {
-	for _, param := range message.Params {
-		matches := CommandRegExp.FindStringSubmatch(param)
+// Part leaves the given channel after storing its state.
+func (b *Bot) Part(name string) {
+	b.mutex.Lock()
+	channel, ok := b.Channels[name]
+	delete(b.Channels, name)
+	b.mutex.Unlock()
 
-		if matches == nil || len(matches) < 1 {
-			continue
+	if ok {
+		channel.Serialize()
+		ctx, cancel := context.WithTimeout(context.Background(), ReplyTimeout)
+
+		if err := channel.Unsubscribe(ctx); err != nil {
+			log.Println(err)
 		}
 
-		command := matches[1]
+		cancel()
+	}
+
+	b.Session.Write("PART #" + name)
+}
+
+// Reconnected restores the connection state after the session has
+// reconnected to the IRC server. Credentials are sent again, capabilities
+// are renegotiated and every channel is rejoined. The ledger and
+// management state are kept as is.
+func (b *Bot) Reconnected() {
+	enabled, ok := b.connect(b.Session, b.Replies, b.capabilities())
+
+	if !ok {
+		log.Println("[Bot]: Unable to authenticate after reconnecting")
+		return
+	}
+
+	b.mutex.Lock()
+	b.enabled = enabled
+	b.mutex.Unlock()
 
-		if callback, ok := BotCommands[command]; ok {
-			callback(b, message)
+	for _, channel := range b.names() {
+		if ok := b.join(channel); !ok {
+			log.Println("[Bot]: Unable to rejoin #" + channel)
 		}
+	}
+}
 
-		break
+// Replay handles the lines recorded in r as if they were received from
+// the IRC server. The bot must have been created by NewReplayBot.
+func (b *Bot) Replay(r io.Reader, speed float64) error {
+	fake, ok := b.Clock.(*clock.Fake)
+
+	if !ok {
+		return errors.New("Replay requires a fake clock")
 	}
+
+	return irc.Replay(r, b, fake, speed)
 }
 
-// Part leaves the given channel.
-func (b *Bot) Part(channel string) {
-	b.Session.Write("PART #" + channel)
+// Replaying schedules the first update once the replay clock has been set
+// to the start of the recording.
+func (b *Bot) Replaying() {
+	b.Timer = b.Clock.AfterFunc(UpdateInterval*time.Second, b.Update)
 }
 
-// PrivMSG sends a private message.
-func (b *Bot) PrivMSG(message string) {
-	b.Session.Write("PRIVMSG #" + b.Config.Twitch.Username + " :" + message)
+// names returns the names of the joined channels in sorted order.
+func (b *Bot) names() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	names := make([]string, 0, len(b.Channels))
+
+	for name := range b.Channels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
 }
 
-// Serialize stores state information of the bot to disk in byte data.
+// Serialize stores the configuration of the bot and the state of every
+// channel to disk in byte data.
 func (b *Bot) Serialize() {
+	b.serializeConfig()
+
+	for _, name := range b.names() {
+		if channel := b.Channel(name); channel != nil {
+			channel.Serialize()
+		}
+	}
+}
+
+// serializeConfig stores the configuration of the bot to disk in byte data.
+// The configuration is locked as the API refreshes the token concurrently
+// and the file is only replaced once written in full.
+func (b *Bot) serializeConfig() {
 	// TODO: Encrypt data.
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 	configPath := b.Config.Files["config"]
-	configFile, err := os.OpenFile(configPath, os.O_CREATE|os.O_WRONLY, 0666)
+	tempPath := configPath + ".tmp"
+	configFile, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
 
 	if err != nil {
 		panic(err)
 	}
 
-	defer configFile.Close()
 	writer := bufio.NewWriter(configFile)
-	b.Config.Serialize(writer)
-	writer.Flush()
 
-	ledgerPath := b.Config.Files["ledger"]
-	ledgerFile, err := os.OpenFile(ledgerPath, os.O_CREATE|os.O_WRONLY, 0666)
-
-	if err != nil {
-		panic(err)
+	if err = b.Config.Serialize(writer); err == nil {
+		err = writer.Flush()
 	}
 
-	defer ledgerFile.Close()
-	writer = bufio.NewWriter(ledgerFile)
-	b.Management.Ledger.Serialize(writer)
-	writer.Flush()
+	if closeErr := configFile.Close(); err == nil {
+		err = closeErr
+	}
 
-	maPath := b.Config.Files["ma"]
-	maFile, err := os.OpenFile(maPath, os.O_CREATE|os.O_WRONLY, 0666)
+	// The previous file is only replaced once the new one was written in full.
+	if err == nil {
+		err = os.Rename(tempPath, configPath)
+	}
 
 	if err != nil {
-		panic(err)
+		log.Println(err)
+		os.Remove(tempPath)
 	}
+}
 
-	defer maFile.Close()
-	writer = bufio.NewWriter(maFile)
-	b.Management.MovingAverage.Serialize(writer)
-	writer.Flush()
+// Subscribe registers the handlers of the bot with the given Dispatcher.
+func (b *Bot) Subscribe(d *tmi.Dispatcher) {
+	d.OnCommand("CAP", func(m irc.Message) { CapReply(b, m) })
+	d.OnCommand("PING", func(m irc.Message) { Ping(b, m) })
+	d.OnClearChat(func(e *tmi.ClearChat) { ClearChat(b, e) })
+	d.OnJoin(func(e *tmi.Join) { Join(b, e) })
+	d.OnNotice(func(e *tmi.Notice) { Notice(b, e) })
+	d.OnPart(func(e *tmi.Part) { Part(b, e) })
+	d.OnPrivMsg(func(e *tmi.PrivMsg) { PrivMSG(b, e) })
+	d.OnReconnect(func(e *tmi.Reconnect) { Reconnect(b, e) })
+	d.OnUserState(func(e *tmi.UserState) { UserState(b, e) })
 }
 
-// Start creates additional goroutines for reading from the IRC server.
+// Start schedules the first update and creates additional goroutines
+// for reading from every session of the Pool and from EventSub.
 func (b *Bot) Start() {
-	go b.Update()
+	b.Timer = b.Clock.AfterFunc(UpdateInterval*time.Second, b.Update)
 	go b.Session.Listen(b)
+
+	if b.EventSub != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		b.cancel = cancel
+		go b.EventSub.Run(ctx)
+	}
+
+	writers := make([]*writer, 0, len(b.Pool.Writers))
+
+	for _, session := range b.Pool.Writers {
+		w := &writer{bot: b, replies: irc.NewCorrelator(), session: session}
+		writers = append(writers, w)
+		go session.Listen(w)
+	}
+
+	b.mutex.Lock()
+	b.writers = writers
+	b.mutex.Unlock()
 }
 
-// Update calls nested update functions and applies changes to moderators.
+// pollStreams retrieves which streams of the joined channels are live.
+// Streams are polled even though EventSub reports when they go live or
+// offline, so that events missed while disconnected are corrected.
+func (b *Bot) pollStreams() {
+	names := b.names()
+
+	if b.API == nil || len(names) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ReplyTimeout)
+	defer cancel()
+	live := make(map[string]bool)
+	streams := b.API.Streams(ctx, names)
+
+	for streams.Next() {
+		live[streams.Value().UserLogin] = true
+	}
+
+	if err := streams.Err(); err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, name := range names {
+		if channel := b.Channel(name); channel != nil {
+			channel.SetLive(live[name])
+		}
+	}
+}
+
+// Update updates every channel one at a time and stores the configuration.
+// The next update is scheduled every UpdateInterval.
 func (b *Bot) Update() {
-	for {
-		<-b.Timer.C
-		b.Management.Update()
-		moderators := make([]string, 0)
-
-		for _, moderator := range b.Management.DPoS.Round.Forgers {
-			account := moderator.Account
-			username := b.Management.Ledger.Username(account.IBAN)
-			moderators = append(moderators, username)
+	b.pollStreams()
+
+	for _, name := range b.names() {
+		if channel := b.Channel(name); channel != nil {
+			log.Println("[Bot]: Updating #" + name)
+			channel.Update()
 		}
+	}
+
+	// Twitch requires tokens in use to be validated hourly.
+	if b.API != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), ReplyTimeout)
+		_, err := b.API.Validate(ctx)
+		cancel()
 
-		b.Amend(moderators)
-		b.Serialize()
-		b.Timer.Reset(UpdateInterval * time.Second)
+		if err != nil {
+			log.Println(err)
+		}
 	}
+
+	b.serializeConfig()
+	metrics := b.Pool.Metrics()
+	log.Printf("[IRC]: Queue depth - %v, Sent - %v, Dropped - %v, Latency - %v", metrics.Depth(), metrics.Sent, metrics.Dropped, metrics.Latency)
+	b.Timer = b.Clock.AfterFunc(UpdateInterval*time.Second, b.Update)
 }
@@ -0,0 +1,423 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kookehs/kneissbot/net/api/twitch"
+	"github.com/kookehs/kneissbot/net/api/twitch/twitchtest"
+	"github.com/kookehs/kneissbot/net/irc"
+	"github.com/kookehs/kneissbot/net/irc/irctest"
+	"golang.org/x/oauth2"
+)
+
+// newIRCBot returns a started replay bot of kneissbot whose Pool of the
+// given number of writers is connected to the server.
+func newIRCBot(t *testing.T, server *irctest.Server, writers int) *Bot {
+	bot, err := NewReplayBot([]string{"kneissbot"}, t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bot.Pool.Close()
+	bot.Config.Twitch.AccessToken = "token"
+	pool, err := irc.NewPool(server.Transport(), writers)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bot.Pool = pool
+	bot.Session = pool.Reader
+	bot.Start()
+	t.Cleanup(func() { bot.Close() })
+	return bot
+}
+
+// waitLines waits until the server received the given lines over every
+// connection, as writes are queued, and returns the lines received.
+func waitLines(server *irctest.Server, want [][]string) [][]string {
+	deadline := time.Now().Add(time.Second)
+
+	for {
+		got := lines(server)
+
+		if reflect.DeepEqual(got, want) || time.Now().After(deadline) {
+			return got
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// login returns the lines of a session logging in and requesting the
+// given capabilities.
+func login(capabilities ...string) []string {
+	lines := []string{"CAP LS 302", "PASS oauth:token", "NICK kneissbot"}
+
+	for _, capability := range capabilities {
+		lines = append(lines, "CAP REQ "+capability)
+	}
+
+	return append(lines, "CAP END")
+}
+
+// lines returns the received messages of every connection as lines.
+func lines(server *irctest.Server) [][]string {
+	connections := make([][]string, 0)
+
+	for _, messages := range server.Connections() {
+		received := make([]string, 0, len(messages))
+
+		for _, message := range messages {
+			received = append(received, message.String())
+		}
+
+		connections = append(connections, received)
+	}
+
+	return connections
+}
+
+// newTestBot stores the given token in the configuration NewBot reads and
+// creates a Bot whose API points at the server without connecting to IRC.
+func newTestBot(t *testing.T, server *twitchtest.Server, token *oauth2.Token) (*Bot, error) {
+	wd, err := os.Getwd()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The home directory is relative to the working directory.
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	options, transport := APIOptions, IRCTransport
+	APIOptions, IRCTransport = server.Options(), irc.DiscardTransport{}
+
+	t.Cleanup(func() {
+		APIOptions, IRCTransport = options, transport
+		os.Chdir(wd)
+	})
+
+	home, err := Home()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(home+"/.kneissbot", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	bot := new(Bot)
+	bot.Config = NewConfig()
+	bot.Config.Files["config"] = home + "/.kneissbot/config.bin"
+	bot.Config.Twitch.SetToken(token)
+	bot.serializeConfig()
+	return NewBot()
+}
+
+func TestNewBot(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.AddToken("token", "", "kneissbot", time.Hour)
+	bot, err := newTestBot(t, server, &oauth2.Token{AccessToken: "token"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer bot.Close()
+
+	if bot.Config.Twitch.Username != "kneissbot" || bot.API.UserID() != server.AddUser("kneissbot") {
+		t.Errorf("Bot of %q with user ID %q", bot.Config.Twitch.Username, bot.API.UserID())
+	}
+
+	if bot.API.Handler != bot || bot.EventSub.URL != server.EventSubURL {
+		t.Error("Bot not set up to handle expiry and receive events")
+	}
+}
+
+func TestNewBotRefresh(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.AddToken("stale", "refresh", "kneissbot", time.Nanosecond)
+	bot, err := newTestBot(t, server, &oauth2.Token{AccessToken: "stale", RefreshToken: "refresh"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer bot.Close()
+	config := NewConfig()
+	file, err := os.Open(bot.Config.Files["config"])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	if err := config.Deserialize(bufio.NewReader(file)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The refreshed token is used and stored for the next start.
+	if access, _ := bot.credentials(); access != "access-1" || config.Twitch.AccessToken != "access-1" || config.Twitch.RefreshToken != "refresh-1" {
+		t.Errorf("Using token %q and stored %q, %q", access, config.Twitch.AccessToken, config.Twitch.RefreshToken)
+	}
+}
+
+func TestNewBotRejected(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	if _, err := newTestBot(t, server, &oauth2.Token{AccessToken: "unknown"}); err != twitch.ErrInvalidToken {
+		t.Errorf("NewBot returned %v, want %v", err, twitch.ErrInvalidToken)
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.SetChatters("kneissbot", "alice", "kneissbot")
+	bot := newAPIBot(t, server, "kneissbot")
+
+	if err := bot.Channel("kneissbot").Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		channel, username string
+		want              bool
+	}{
+		{"kneissbot", "alice", true},
+		{"kneissbot", "kneissbot", true},
+		{"kneissbot", "bob", false},
+		{"other", "alice", false},
+	}
+
+	for _, test := range tests {
+		if got := bot.Available(test.channel, test.username); got != test.want {
+			t.Errorf("Available(%q, %q) = %v, want %v", test.channel, test.username, got, test.want)
+		}
+	}
+}
+
+func TestRefreshedSerializesConfig(t *testing.T) {
+	bot, err := NewReplayBot([]string{"kneissbot"}, t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+
+	// Refreshes arrive from the API while updates store the configuration.
+	for i := 0; i < 20; i++ {
+		wg.Add(3)
+
+		go func(i int) {
+			defer wg.Done()
+			bot.Refreshed(&oauth2.Token{AccessToken: "token-" + strconv.Itoa(i), RefreshToken: "refresh"})
+		}(i)
+
+		go func() {
+			defer wg.Done()
+			bot.serializeConfig()
+		}()
+
+		go func() {
+			defer wg.Done()
+			bot.credentials()
+		}()
+	}
+
+	wg.Wait()
+	bot.Refreshed(&oauth2.Token{AccessToken: "final", RefreshToken: "refresh"})
+	file, err := os.Open(bot.Config.Files["config"])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+	config := NewConfig()
+
+	if err := config.Deserialize(bufio.NewReader(file)); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Twitch.AccessToken != "final" || config.Twitch.RefreshToken != "refresh" {
+		t.Errorf("stored token %q, %q", config.Twitch.AccessToken, config.Twitch.RefreshToken)
+	}
+
+	if _, err := os.Stat(bot.Config.Files["config"] + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	server, err := irctest.NewServer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+	bot := newIRCBot(t, server, 1)
+
+	if !bot.Connect() {
+		t.Fatal("Unable to connect")
+	}
+
+	// Capabilities are listed before logging in and negotiated per session.
+	want := [][]string{
+		login(twitch.CommandsCapability, twitch.MembershipCapability, twitch.TagsCapability),
+		login(twitch.CommandsCapability, twitch.TagsCapability),
+	}
+
+	if got := waitLines(server, want); !reflect.DeepEqual(got, want) {
+		t.Errorf("Server received\n%q\nwant\n%q", got, want)
+	}
+
+	for _, capability := range irctest.Capabilities {
+		if !bot.Enabled(capability) {
+			t.Errorf("Capability %v not enabled", capability)
+		}
+	}
+}
+
+func TestConnectWriterCapabilities(t *testing.T) {
+	server, err := irctest.NewServer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+	bot := newIRCBot(t, server, 1)
+
+	if !bot.Connect() {
+		t.Fatal("Unable to connect")
+	}
+
+	if err := bot.Cap([]string{twitch.CommandsCapability}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A writer acknowledging tags after reconnecting does not enable them for the bot.
+	if err := bot.Pool.Writers[0].Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := login(writerCapabilities...)
+	deadline := time.Now().Add(time.Second)
+
+	for got := lines(server); len(got) < 3 || !reflect.DeepEqual(got[2], want); got = lines(server) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Server received %q after reconnecting the writer", got)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if bot.Enabled(twitch.TagsCapability) || !bot.Enabled(twitch.CommandsCapability) {
+		t.Error("Writer changed the capabilities of the bot")
+	}
+}
+
+func TestConnectRejected(t *testing.T) {
+	server, err := irctest.NewWebsocketServer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+	bot := newIRCBot(t, server, 0)
+	bot.Config.Twitch.AccessToken = ""
+
+	if bot.Connect() {
+		t.Error("Connected without a token")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	server, err := irctest.NewServer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+	bot := newIRCBot(t, server, 1)
+
+	if !bot.Connect() {
+		t.Fatal("Unable to connect")
+	}
+
+	if !bot.Join("other") {
+		t.Fatal("Unable to join")
+	}
+
+	// Channels are joined by the reader alone.
+	want := [][]string{
+		append(login(defaultCapabilities...), "JOIN #other"),
+		login(writerCapabilities...),
+	}
+
+	if got := waitLines(server, want); !reflect.DeepEqual(got, want) {
+		t.Errorf("Server received\n%q\nwant\n%q", got, want)
+	}
+
+	if bot.Channel("other") == nil || !reflect.DeepEqual(bot.names(), []string{"kneissbot", "other"}) {
+		t.Errorf("Joined channels %v", bot.names())
+	}
+}
+
+func TestUserStateRateLimit(t *testing.T) {
+	server, err := irctest.NewServer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+	server.SetModerators("moderated", "kneissbot")
+	bot := newIRCBot(t, server, 0)
+
+	if !bot.Connect() {
+		t.Fatal("Unable to connect")
+	}
+
+	for _, channel := range []string{"kneissbot", "moderated", "other"} {
+		if !bot.Join(channel) {
+			t.Fatal("Unable to join #" + channel)
+		}
+	}
+
+	// USERSTATE follows the reply to joining.
+	deadline := time.Now().Add(time.Second)
+
+	for !(bot.Pool.RateLimit.Moderator("kneissbot") && bot.Pool.RateLimit.Moderator("moderated")) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	want := map[string]bool{"kneissbot": true, "moderated": true, "other": false}
+
+	for channel, moderator := range want {
+		if got := bot.Pool.RateLimit.Moderator(channel); got != moderator {
+			t.Errorf("Moderator(%q) = %v, want %v", channel, got, moderator)
+		}
+	}
+}
@@ -0,0 +1,592 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kookehs/kneissbot/net/api/twitch"
+	"github.com/kookehs/kneissbot/net/irc"
+	"github.com/kookehs/kneissbot/net/irc/tmi"
+	"github.com/kookehs/watchmen/primitives"
+)
+
+// Channel contains the state of a single moderated channel. Every channel
+// has its own ledger, delegates and moving averages stored in its own
+// directory. Presence tracks which users are in the chat. Moderators are
+// only managed while the stream is live.
+type Channel struct {
+	Bot        *Bot
+	Files      map[string]string
+	ID         string
+	Management *Management
+	Name       string
+	Presence   *Presence
+
+	known   bool
+	live    bool
+	mutex   sync.Mutex
+	started bool
+}
+
+// Amendment is the outcome of adding or removing a single moderator.
+type Amendment struct {
+	Err       error
+	Moderator bool
+	Username  string
+}
+
+// NewChannel creates and initializes a Channel storing its state in the
+// given directory. The broadcaster owns the genesis account.
+func NewChannel(bot *Bot, name, dir string) *Channel {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Println(err)
+	}
+
+	channel := &Channel{
+		Bot:      bot,
+		Files:    make(map[string]string),
+		Name:     name,
+		Presence: NewPresence(),
+	}
+
+	channel.Files["ledger"] = dir + "/ledger.bin"
+	channel.Files["ma"] = dir + "/ma.bin"
+	channel.Management = NewManagement(channel, name)
+	return channel
+}
+
+// Balance returns the user's balance or a set of users.
+func Balance(channel *Channel, event *tmi.PrivMsg) {
+	username := event.Login
+	users := make([]string, 0)
+
+	if matches := BalanceRegExp.FindStringSubmatch(event.Text); len(matches) > 1 {
+		users = strings.Fields(matches[1])
+	}
+
+	if len(users) == 0 {
+		users = append(users, username)
+	}
+
+	// Respond with a whisper to the user who requested the balance(s).
+	buffer := bytes.NewBufferString("/w ")
+	buffer.WriteString(username)
+	buffer.WriteByte(' ')
+
+	for _, user := range users {
+		iban := channel.Management.Ledger.Users[user]
+
+		if block := channel.Management.Ledger.LatestBlock(iban); block != nil {
+			balance := block.Balance()
+			buffer.WriteString(user)
+			buffer.WriteByte('(')
+			buffer.WriteString(balance.Text('f', -1))
+			buffer.WriteString(") ")
+		}
+	}
+
+	channel.PrivMSG(buffer.String())
+}
+
+// Register creates an account for the given user in the ledger.
+func Register(channel *Channel, event *tmi.PrivMsg) {
+	username := event.Login
+
+	if _, ok := channel.Management.Ledger.Users[username]; !ok {
+		channel.Management.Ledger.OpenAccount(channel.Management.Node, username)
+	}
+}
+
+// Send sends tokens from one user to another.
+func Send(channel *Channel, event *tmi.PrivMsg) {
+	username := event.Login
+	matches := SendRegExp.FindStringSubmatch(event.Text)
+
+	if len(matches) < 3 {
+		return
+	}
+
+	receiver := matches[1]
+	amount, err := strconv.Atoi(matches[2])
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	funds := primitives.NewAmount(float64(amount))
+	src := channel.Management.Ledger.Users[username]
+	dst := channel.Management.Ledger.Users[receiver]
+
+	if _, err = channel.Management.Ledger.Transfer(funds, dst, src, channel.Management.Node); err != nil {
+		log.Println(err)
+	}
+}
+
+// Vote handles a user's choice to add or remove delegates.
+func Vote(channel *Channel, event *tmi.PrivMsg) {
+	username := event.Login
+	matches := VoteRegExp.FindStringSubmatch(event.Text)
+
+	if len(matches) < 2 {
+		return
+	}
+
+	delegates := strings.Fields(matches[1])
+	iban := channel.Management.Ledger.Users[username]
+	account := channel.Management.Ledger.Accounts[iban.String()]
+
+	if err := channel.Management.DPoS.Elect(account, delegates, channel.Management.Ledger, channel.Management.Node); err != nil {
+		log.Println(err)
+	}
+}
+
+// Amend makes changes to the current set of moderators through the Twitch
+// API and returns the outcome for every user added or removed. Without an
+// API, such as when replaying, moderators are left untouched.
+func (c *Channel) Amend(ctx context.Context, moderators []string) []Amendment {
+	if c.Bot.API == nil {
+		return nil
+	}
+
+	broadcaster, err := c.resolveID(ctx)
+
+	if err != nil {
+		return []Amendment{{Err: err, Username: c.Name}}
+	}
+
+	current, err := c.Bot.API.GetModerators(ctx, broadcaster, nil)
+
+	if err != nil {
+		return []Amendment{{Err: err, Username: c.Name}}
+	}
+
+	// A mapping of users to mod or unmod.
+	amendment := make(map[string]bool)
+	ids := make(map[string]string)
+
+	for _, moderator := range moderators {
+		amendment[moderator] = true
+	}
+
+	for _, moderator := range current {
+		ids[moderator.UserLogin] = moderator.UserID
+
+		if _, exist := amendment[moderator.UserLogin]; exist {
+			delete(amendment, moderator.UserLogin)
+		} else {
+			amendment[moderator.UserLogin] = false
+		}
+	}
+
+	usernames := make([]string, 0, len(amendment))
+	logins := make([]string, 0)
+
+	for username, operator := range amendment {
+		usernames = append(usernames, username)
+
+		if operator {
+			logins = append(logins, username)
+		}
+	}
+
+	sort.Strings(usernames)
+
+	if len(logins) > 0 {
+		users, err := c.Bot.API.GetUsers(ctx, nil, logins)
+
+		if err != nil {
+			log.Println(err)
+		} else {
+			for _, user := range users.Data {
+				ids[user.Login] = user.ID
+			}
+		}
+	}
+
+	results := make([]Amendment, 0, len(usernames))
+
+	for _, username := range usernames {
+		result := Amendment{Moderator: amendment[username], Username: username}
+		id, ok := ids[username]
+
+		switch {
+		case !ok:
+			result.Err = errors.New("Unknown user")
+		case result.Moderator:
+			result.Err = c.Bot.API.AddModerator(ctx, broadcaster, id)
+		default:
+			result.Err = c.Bot.API.RemoveModerator(ctx, broadcaster, id)
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// Available returns whether or not the given user is in the channel.
+// Without an API, such as when replaying, every user is available.
+func (c *Channel) Available(username string) bool {
+	if c.Bot.API == nil {
+		return true
+	}
+
+	return c.Presence.Available(username)
+}
+
+// Deserialize retrieves state information of the channel from disk.
+func (c *Channel) Deserialize() {
+	ledgerPath := c.Files["ledger"]
+	ledgerFile, err := os.OpenFile(ledgerPath, os.O_RDONLY, 0666)
+
+	if err == nil {
+		defer ledgerFile.Close()
+		reader := bufio.NewReader(ledgerFile)
+		c.Management.Ledger.Deserialize(reader)
+	}
+
+	maPath := c.Files["ma"]
+	maFile, err := os.OpenFile(maPath, os.O_RDONLY, 0666)
+
+	if err == nil {
+		defer maFile.Close()
+		reader := bufio.NewReader(maFile)
+		c.Management.MovingAverage.Deserialize(reader)
+	}
+}
+
+// ParseCommand parses commands related to the bot.
+func (c *Channel) ParseCommand(event *tmi.PrivMsg) {
+	matches := CommandRegExp.FindStringSubmatch(event.Text)
+
+	if len(matches) < 2 {
+		return
+	}
+
+	if callback, ok := BotCommands[matches[1]]; ok {
+		callback(c, event)
+	}
+}
+
+// PrivMSG sends a private message to the channel. Long messages are split
+// into several with a leading whisper or action command kept on each.
+func (c *Channel) PrivMSG(message string) {
+	prefix, text := splitCommand(message)
+
+	for _, chunk := range c.split(prefix, text) {
+		c.Bot.Pool.Write("PRIVMSG #" + c.Name + " :" + chunk)
+	}
+}
+
+// Live returns whether or not the stream of the channel is live. Without
+// an API, such as when replaying, the stream is always considered live.
+func (c *Channel) Live() bool {
+	if c.Bot.API == nil {
+		return true
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.live
+}
+
+// SetLive records whether or not the stream of the channel is live. The
+// moving averages are rebaselined on the next update after the stream
+// started. The first status is not a change, so that a restart during a
+// stream keeps the moving averages.
+func (c *Channel) SetLive(live bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.known {
+		c.known = true
+		c.live = live
+		return
+	}
+
+	if c.live == live {
+		return
+	}
+
+	if live {
+		log.Println("[Bot]: #" + c.Name + " went live")
+		c.started = true
+	} else {
+		log.Println("[Bot]: #" + c.Name + " went offline")
+	}
+
+	c.live = live
+}
+
+// Refresh replaces the users present in the channel with a snapshot of
+// the chatters retrieved from the Twitch API.
+func (c *Channel) Refresh(ctx context.Context) error {
+	if c.Bot.API == nil {
+		return nil
+	}
+
+	id, err := c.resolveID(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	start := c.Bot.Clock.Now()
+	chatters := c.Bot.API.Chatters(ctx, id, c.Bot.API.UserID())
+	usernames := make([]string, 0, c.Presence.Len())
+
+	for chatters.Next() {
+		usernames = append(usernames, chatters.Value().UserLogin)
+	}
+
+	if err := chatters.Err(); err != nil {
+		return err
+	}
+
+	c.Presence.Snapshot(usernames, start)
+	return nil
+}
+
+// Reply sends a private message as a threaded reply to the given message.
+// Long messages are split into several replies to the same message.
+func (c *Channel) Reply(parent *tmi.PrivMsg, message string) {
+	for _, chunk := range c.split("", message) {
+		reply := irc.Message{
+			Command: "PRIVMSG",
+			Params:  []string{"#" + c.Name, chunk},
+			Tags:    make(map[string]string),
+		}
+
+		if len(parent.ID) > 0 {
+			reply.Tags["reply-parent-msg-id"] = parent.ID
+		}
+
+		var buffer bytes.Buffer
+
+		if err := reply.Encode(&buffer); err != nil {
+			log.Println(err)
+			continue
+		}
+
+		c.Bot.Pool.Write(buffer.String())
+	}
+}
+
+// resolveID returns the user ID of the broadcaster, retrieving it unless
+// known. The channel is not locked while waiting for the Twitch API.
+func (c *Channel) resolveID(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	id := c.ID
+	c.mutex.Unlock()
+
+	if len(id) > 0 {
+		return id, nil
+	}
+
+	users, err := c.Bot.API.GetUsers(ctx, nil, []string{c.Name})
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(users.Data) == 0 {
+		return "", errors.New("Unable to retrieve broadcaster")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ID = users.Data[0].ID
+	return c.ID, nil
+}
+
+// Subscribe creates the EventSub subscriptions of the channel. They are
+// kept by the EventSub client and created again for every new session.
+func (c *Channel) Subscribe(ctx context.Context) error {
+	if c.Bot.EventSub == nil {
+		return nil
+	}
+
+	if _, err := c.resolveID(ctx); err != nil {
+		return err
+	}
+
+	return c.Bot.EventSub.Subscribe(ctx, c.Subscriptions()...)
+}
+
+// Subscribed returns whether or not events of the given subscription type
+// are received for the channel.
+func (c *Channel) Subscribed(subscriptionType string) bool {
+	if c.Bot.EventSub == nil {
+		return false
+	}
+
+	for _, subscription := range c.Subscriptions() {
+		if strings.Compare(subscription.Type, subscriptionType) == 0 {
+			return c.Bot.EventSub.Subscribed(subscription)
+		}
+	}
+
+	return false
+}
+
+// Subscriptions returns the EventSub subscriptions to the moderation,
+// stream, raid and channel points events of the channel. Deleted chat
+// messages are read on behalf of the bot.
+func (c *Channel) Subscriptions() []twitch.Subscription {
+	c.mutex.Lock()
+	id := c.ID
+	c.mutex.Unlock()
+	broadcaster := map[string]string{"broadcaster_user_id": id}
+	raided := map[string]string{"to_broadcaster_user_id": id}
+	reader := map[string]string{"broadcaster_user_id": id, "user_id": c.Bot.API.UserID()}
+
+	return []twitch.Subscription{
+		{Condition: broadcaster, Type: twitch.ChannelBan, Version: "1"},
+		{Condition: reader, Type: twitch.ChannelChatMessageDelete, Version: "1"},
+		{Condition: broadcaster, Type: twitch.ChannelModeratorAdd, Version: "1"},
+		{Condition: broadcaster, Type: twitch.ChannelModeratorRemove, Version: "1"},
+		{Condition: broadcaster, Type: twitch.ChannelPointsRedemptionAdd, Version: "1"},
+		{Condition: raided, Type: twitch.ChannelRaid, Version: "1"},
+		{Condition: broadcaster, Type: twitch.ChannelUnban, Version: "1"},
+		{Condition: broadcaster, Type: twitch.StreamOffline, Version: "1"},
+		{Condition: broadcaster, Type: twitch.StreamOnline, Version: "1"},
+	}
+}
+
+// Unsubscribe deletes the EventSub subscriptions of the channel.
+func (c *Channel) Unsubscribe(ctx context.Context) error {
+	if c.Bot.EventSub == nil {
+		return nil
+	}
+
+	return c.Bot.EventSub.Unsubscribe(ctx, c.Subscriptions()...)
+}
+
+// Serialize stores state information of the channel to disk in byte data.
+func (c *Channel) Serialize() {
+	// TODO: Encrypt data.
+	ledgerPath := c.Files["ledger"]
+	ledgerFile, err := os.OpenFile(ledgerPath, os.O_CREATE|os.O_WRONLY, 0666)
+
+	if err != nil {
+		panic(err)
+	}
+
+	defer ledgerFile.Close()
+	writer := bufio.NewWriter(ledgerFile)
+	c.Management.Ledger.Serialize(writer)
+	writer.Flush()
+
+	maPath := c.Files["ma"]
+	maFile, err := os.OpenFile(maPath, os.O_CREATE|os.O_WRONLY, 0666)
+
+	if err != nil {
+		panic(err)
+	}
+
+	defer maFile.Close()
+	writer = bufio.NewWriter(maFile)
+	c.Management.MovingAverage.Serialize(writer)
+	writer.Flush()
+}
+
+// Update calls nested update functions and applies changes to moderators.
+// The chatters are refreshed before the election so that only users
+// present in the chat are elected, while moderators are amended in a
+// separate goroutine as it waits on the Twitch API. While the stream is
+// offline, idle chat would skew the moving averages, so the counters are
+// discarded and moderators are left as they are unless OfflineUpdates is
+// set. Until the status of the stream is known, such as when polling
+// streams failed, the counters are kept. The first update after the stream
+// started only rebaselines the moving averages as the counters span the
+// time before.
+func (c *Channel) Update() {
+	c.mutex.Lock()
+	started := c.started
+	c.started = false
+	known := c.known || c.Bot.API == nil
+	c.mutex.Unlock()
+
+	switch {
+	case started:
+		log.Println("[Bot]: Rebaselining #" + c.Name)
+		c.Management.Rebaseline()
+		c.Serialize()
+		return
+	case !c.Live() && !OfflineUpdates:
+		// The counters are only discarded once the stream is known to be offline.
+		if known {
+			c.Management.Reset()
+			c.Serialize()
+		} else {
+			log.Println("[Bot]: Skipping update of #" + c.Name + ", stream status unknown")
+		}
+
+		return
+	}
+
+	// Refreshing and amending must finish before the next update.
+	ctx, cancel := context.WithTimeout(context.Background(), UpdateInterval*time.Second)
+
+	if err := c.Refresh(ctx); err != nil {
+		log.Println(err)
+	}
+
+	c.Management.Update()
+	moderators := make([]string, 0)
+
+	for _, moderator := range c.Management.DPoS.Round.Forgers {
+		account := moderator.Account
+		username := c.Management.Ledger.Username(account.IBAN)
+		moderators = append(moderators, username)
+	}
+
+	go func() {
+		defer cancel()
+
+		for _, result := range c.Amend(ctx, moderators) {
+			if result.Err != nil {
+				log.Printf("[Bot]: Unable to amend %v in #%v - %v", result.Username, c.Name, result.Err)
+			}
+		}
+	}()
+
+	c.Serialize()
+}
+
+// split splits the text into chunks with tmi.Split and logs text dropped
+// because the prefix leaves no room for it.
+func (c *Channel) split(prefix, text string) []string {
+	chunks := tmi.Split(prefix, text)
+
+	if len(chunks) == 0 && len(strings.TrimSpace(text)) > 0 {
+		log.Printf("[Bot]: Message to #%v dropped, no room after %q", c.Name, prefix)
+	}
+
+	return chunks
+}
+
+// splitCommand separates a leading /me or /w user command from the text of
+// the message.
+func splitCommand(message string) (string, string) {
+	fields := strings.SplitN(message, " ", 3)
+
+	switch {
+	case strings.Compare(fields[0], "/me") == 0 && len(fields) > 1:
+		return "/me ", strings.TrimPrefix(message, "/me ")
+	case strings.Compare(fields[0], "/w") == 0 && len(fields) > 2:
+		return "/w " + fields[1] + " ", fields[2]
+	}
+
+	return "", message
+}
@@ -0,0 +1,224 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kookehs/kneissbot/net/api/twitch"
+	"github.com/kookehs/kneissbot/net/api/twitch/twitchtest"
+	"github.com/kookehs/kneissbot/net/irc/irctest"
+)
+
+func TestAmend(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.AddUser("carol")
+	server.SetModerators("kneissbot", "alice", "bob")
+	bot := newAPIBot(t, server, "kneissbot")
+	results := bot.Channel("kneissbot").Amend(context.Background(), []string{"alice", "carol", "nobody"})
+	want := []Amendment{
+		{Moderator: false, Username: "bob"},
+		{Moderator: true, Username: "carol"},
+		{Moderator: true, Username: "nobody"},
+	}
+
+	if len(results) != len(want) {
+		t.Fatalf("Amend returned %+v", results)
+	}
+
+	for i, result := range results {
+		if result.Username != want[i].Username || result.Moderator != want[i].Moderator {
+			t.Errorf("Amendment %v = %+v, want %+v", i, result, want[i])
+		}
+
+		if unknown := result.Username == "nobody"; unknown != (result.Err != nil) {
+			t.Errorf("Amendment of %v returned error %v", result.Username, result.Err)
+		}
+	}
+
+	if got := server.Moderators("kneissbot"); !reflect.DeepEqual(got, []string{"alice", "carol"}) {
+		t.Errorf("Server has moderators %v", got)
+	}
+}
+
+func TestAmendJoined(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	chat, err := irctest.NewServer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer chat.Close()
+	server.AddToken("token", "", "kneissbot", 0)
+	server.AddUser("carol")
+	server.SetModerators("kneissbot", "alice")
+	bot := newIRCBot(t, chat, 0)
+	bot.API = twitch.NewAPI("token", server.Options()...)
+
+	if !bot.Connect() || !bot.Join("kneissbot") {
+		t.Fatal("Unable to join")
+	}
+
+	for _, result := range bot.Channel("kneissbot").Amend(context.Background(), []string{"carol"}) {
+		if result.Err != nil {
+			t.Errorf("Unable to amend %v - %v", result.Username, result.Err)
+		}
+	}
+
+	if got := server.Moderators("kneissbot"); !reflect.DeepEqual(got, []string{"carol"}) {
+		t.Errorf("Server has moderators %v", got)
+	}
+
+	// Moderators are amended through Helix rather than chat commands.
+	want := [][]string{append(login(defaultCapabilities...), "JOIN #kneissbot")}
+
+	if got := waitLines(chat, want); !reflect.DeepEqual(got, want) {
+		t.Errorf("IRC server received %q", got)
+	}
+}
+
+// stalled is a RoundTripper whose requests only end with their context.
+type stalled chan struct{}
+
+func (s stalled) RoundTrip(r *http.Request) (*http.Response, error) {
+	s <- struct{}{}
+	<-r.Context().Done()
+	return nil, r.Context().Err()
+}
+
+func TestResolveIDUnlocked(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	bot := newAPIBot(t, server, "kneissbot")
+	requests := make(stalled, 1)
+	bot.API = twitch.NewAPI("token", append(server.Options(), twitch.WithClient(&http.Client{Transport: requests}))...)
+	channel := bot.Channel("kneissbot")
+	ctx, cancel := context.WithCancel(context.Background())
+	resolved := make(chan error)
+
+	go func() {
+		_, err := channel.resolveID(ctx)
+		resolved <- err
+	}()
+
+	<-requests
+	done := make(chan struct{})
+
+	// The channel stays usable while the broadcaster is retrieved.
+	go func() {
+		channel.SetLive(true)
+		channel.Live()
+		channel.Subscriptions()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Channel locked while retrieving the broadcaster")
+	}
+
+	cancel()
+
+	if err := <-resolved; err == nil {
+		t.Error("resolveID succeeded although the request was cancelled")
+	}
+
+	<-done
+}
+
+func TestUpdateRefreshesBeforeElection(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.SetChatters("kneissbot", "alice")
+	server.SetModerators("kneissbot", "bob")
+	bot := newAPIBot(t, server, "kneissbot")
+	channel := bot.Channel("kneissbot")
+	channel.SetLive(true)
+	channel.Update()
+
+	// The chatters are known by the time moderators are elected.
+	if !channel.Available("alice") {
+		t.Error("Chatters not refreshed before the election")
+	}
+
+	// Nobody was elected, so moderators are removed once amended.
+	deadline := time.Now().Add(time.Second)
+
+	for len(server.Moderators("kneissbot")) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Moderators %v not amended", server.Moderators("kneissbot"))
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUpdateRebaselinesOnLive(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	bot := newAPIBot(t, server, "kneissbot")
+	channel := bot.Channel("kneissbot")
+	bot.pollStreams()
+	server.SetLive("kneissbot", true)
+	bot.pollStreams()
+
+	if !channel.Live() {
+		t.Fatal("Stream not live after polling")
+	}
+
+	average := channel.Management.MovingAverage
+	channel.Management.Message()
+	channel.Update()
+
+	if channel.Management.MovingAverage == average || channel.Management.Messages != 0 {
+		t.Error("Moving averages not rebaselined after going live")
+	}
+
+	// Only the first update after going live rebaselines.
+	average = channel.Management.MovingAverage
+	bot.pollStreams()
+	channel.Update()
+
+	if channel.Management.MovingAverage != average {
+		t.Error("Moving averages rebaselined while live")
+	}
+}
+
+func TestUpdateOffline(t *testing.T) {
+	delay := twitch.RetryMinDelay
+	twitch.RetryMinDelay = time.Millisecond
+	defer func() { twitch.RetryMinDelay = delay }()
+	server := twitchtest.NewServer()
+	defer server.Close()
+	bot := newAPIBot(t, server, "kneissbot")
+	channel := bot.Channel("kneissbot")
+
+	// Counters are kept while polling has not reported the stream.
+	server.Fail(http.StatusServiceUnavailable, twitch.MaxRetries+1)
+	bot.pollStreams()
+	channel.Management.Message()
+	channel.Update()
+
+	if channel.Management.Messages != 1 {
+		t.Errorf("Counted %v messages after failing to poll, want 1", channel.Management.Messages)
+	}
+
+	// Counters are discarded once polling reported the stream offline.
+	bot.pollStreams()
+
+	if channel.Live() {
+		t.Fatal("Stream live after polling")
+	}
+
+	channel.Update()
+
+	if channel.Management.Messages != 0 {
+		t.Errorf("Counted %v messages while offline, want 0", channel.Management.Messages)
+	}
+}
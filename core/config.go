@@ -3,7 +3,20 @@ package core
 import (
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"io"
+	"time"
+
+	"github.com/kookehs/kneissbot/net/api/twitch"
+	"github.com/kookehs/kneissbot/net/irc"
+	"golang.org/x/oauth2"
+)
+
+// Transports supported by TwitchConfig.
+const (
+	TCPTransport       = "tcp"
+	TLSTransport       = "tls"
+	WebsocketTransport = "websocket"
 )
 
 // Config contains configuration variables for the bot.
@@ -46,7 +59,45 @@ func (c *Config) SerializeJSON(w io.Writer) error {
 }
 
 // TwitchConfig contains variables for Twitch related configurations.
+// Transport selects how to connect to Twitch IRC and defaults to websocket.
+// Writers is the number of additional connections outgoing messages are
+// spread across. The access token is refreshed with RefreshToken before
+// it expires at Expiry.
 type TwitchConfig struct {
-	AccessToken string
-	Username    string
+	AccessToken  string
+	Expiry       time.Time
+	RefreshToken string
+	Transport    string
+	Username     string
+	Writers      int
+}
+
+// Token returns the OAuth 2 token stored in the TwitchConfig.
+func (tc *TwitchConfig) Token() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  tc.AccessToken,
+		Expiry:       tc.Expiry,
+		RefreshToken: tc.RefreshToken,
+	}
+}
+
+// SetToken stores the given OAuth 2 token in the TwitchConfig.
+func (tc *TwitchConfig) SetToken(token *oauth2.Token) {
+	tc.AccessToken = token.AccessToken
+	tc.Expiry = token.Expiry
+	tc.RefreshToken = token.RefreshToken
+}
+
+// NewTransport returns the irc.Transport for connecting to Twitch IRC by the given name.
+func NewTransport(name string) (irc.Transport, error) {
+	switch name {
+	case "", WebsocketTransport:
+		return &irc.WebsocketTransport{Origin: twitch.Origin, URL: twitch.IRC}, nil
+	case TCPTransport:
+		return &irc.TCPTransport{Address: twitch.IRCTCP}, nil
+	case TLSTransport:
+		return &irc.TLSTransport{Address: twitch.IRCTLS}, nil
+	default:
+		return nil, errors.New("Unsupported transport " + name)
+	}
 }
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"log"
+
+	"github.com/kookehs/kneissbot/net/api/twitch"
+)
+
+// Ban is the handler for channel.ban events sent from EventSub. Unlike
+// CLEARCHAT, the event tells bans and timeouts apart and names the
+// moderator responsible.
+func Ban(bot *Bot, event *twitch.BanEvent) {
+	channel := bot.Channel(event.BroadcasterUserLogin)
+
+	if channel == nil {
+		return
+	}
+
+	channel.Management.Ban(event.ModeratorUserLogin, event.IsPermanent)
+	log.Printf("[Bot]: %v banned %v in #%v - %v", event.ModeratorUserLogin, event.UserLogin, channel.Name, event.Reason)
+}
+
+// MessageDelete is the handler for channel.chat.message_delete events sent
+// from EventSub.
+func MessageDelete(bot *Bot, event *twitch.MessageDeleteEvent) {
+	if channel := bot.Channel(event.BroadcasterUserLogin); channel != nil {
+		channel.Management.Delete()
+	}
+}
+
+// ModeratorAdd is the handler for channel.moderator.add events sent from
+// EventSub.
+func ModeratorAdd(bot *Bot, event *twitch.ModeratorEvent) {
+	log.Printf("[Bot]: %v added as moderator in #%v", event.UserLogin, event.BroadcasterUserLogin)
+}
+
+// ModeratorRemove is the handler for channel.moderator.remove events sent
+// from EventSub.
+func ModeratorRemove(bot *Bot, event *twitch.ModeratorEvent) {
+	log.Printf("[Bot]: %v removed as moderator in #%v", event.UserLogin, event.BroadcasterUserLogin)
+}
+
+// Raid is the handler for channel.raid events sent from EventSub.
+func Raid(bot *Bot, event *twitch.RaidEvent) {
+	log.Printf("[Bot]: %v raided #%v with %v viewers", event.FromBroadcasterUserLogin, event.ToBroadcasterUserLogin, event.Viewers)
+}
+
+// Redemption is the handler for channel points redemption events sent
+// from EventSub.
+func Redemption(bot *Bot, event *twitch.RedemptionEvent) {
+	log.Printf("[Bot]: %v redeemed %v in #%v", event.UserLogin, event.Reward.Title, event.BroadcasterUserLogin)
+}
+
+// StreamOffline is the handler for stream.offline events sent from EventSub.
+func StreamOffline(bot *Bot, event *twitch.StreamOfflineEvent) {
+	if channel := bot.Channel(event.BroadcasterUserLogin); channel != nil {
+		channel.SetLive(false)
+	}
+}
+
+// StreamOnline is the handler for stream.online events sent from EventSub.
+func StreamOnline(bot *Bot, event *twitch.StreamOnlineEvent) {
+	if channel := bot.Channel(event.BroadcasterUserLogin); channel != nil {
+		channel.SetLive(true)
+	}
+}
+
+// Unban is the handler for channel.unban events sent from EventSub.
+func Unban(bot *Bot, event *twitch.UnbanEvent) {
+	log.Printf("[Bot]: %v unbanned %v in #%v", event.ModeratorUserLogin, event.UserLogin, event.BroadcasterUserLogin)
+}
+
+// SubscribeEventSub registers the EventSub handlers of the bot with the
+// given EventSub client.
+func (b *Bot) SubscribeEventSub(es *twitch.EventSub) {
+	es.OnBan(func(e *twitch.BanEvent) { Ban(b, e) })
+	es.OnMessageDelete(func(e *twitch.MessageDeleteEvent) { MessageDelete(b, e) })
+	es.OnModeratorAdd(func(e *twitch.ModeratorEvent) { ModeratorAdd(b, e) })
+	es.OnModeratorRemove(func(e *twitch.ModeratorEvent) { ModeratorRemove(b, e) })
+	es.OnRaid(func(e *twitch.RaidEvent) { Raid(b, e) })
+	es.OnRedemption(func(e *twitch.RedemptionEvent) { Redemption(b, e) })
+	es.OnStreamOffline(func(e *twitch.StreamOfflineEvent) { StreamOffline(b, e) })
+	es.OnStreamOnline(func(e *twitch.StreamOnlineEvent) { StreamOnline(b, e) })
+	es.OnUnban(func(e *twitch.UnbanEvent) { Unban(b, e) })
+}
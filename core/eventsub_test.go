@@ -0,0 +1,118 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kookehs/kneissbot/net/api/twitch"
+	"github.com/kookehs/kneissbot/net/api/twitch/twitchtest"
+	"github.com/kookehs/kneissbot/net/irc/tmi"
+)
+
+// newAPIBot returns a replay bot of the given channel whose API and
+// EventSub point at the server. EventSub runs until the test ends.
+func newAPIBot(t *testing.T, server *twitchtest.Server, channel string) *Bot {
+	bot, err := NewReplayBot([]string{channel}, t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.AddToken("token", "", channel, 0)
+	bot.API = twitch.NewAPI("token", server.Options()...)
+
+	if _, err := bot.API.Validate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	bot.EventSub = twitch.NewEventSub(bot.API)
+	bot.SubscribeEventSub(bot.EventSub)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		bot.EventSub.Run(ctx)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	deadline := time.Now().Add(time.Second)
+
+	for !bot.EventSub.Connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("EventSub not connected")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	return bot
+}
+
+func TestClearChatFallback(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	bot := newAPIBot(t, server, "kneissbot")
+	channel := bot.Channel("kneissbot")
+	timeout := &tmi.ClearChat{BanDuration: 10 * time.Second, Login: "spammer"}
+	timeout.Channel = "kneissbot"
+
+	if _, err := channel.resolveID(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// CLEARCHAT is counted while channel.ban events are not received.
+	server.Fail(http.StatusForbidden, len(channel.Subscriptions()))
+
+	if err := channel.Subscribe(context.Background()); err == nil {
+		t.Fatal("Subscribe succeeded although the server failed")
+	}
+
+	ClearChat(bot, timeout)
+
+	if channel.Management.Timeouts != 1 {
+		t.Fatalf("Counted %v timeouts from CLEARCHAT, want 1", channel.Management.Timeouts)
+	}
+
+	// Once subscribed, bans are counted from EventSub alone.
+	if err := channel.Subscribe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ClearChat(bot, timeout)
+	event := twitch.BanEvent{EndsAt: "soon"}
+	event.BroadcasterUserLogin = "kneissbot"
+	event.ModeratorUserLogin = "moderator"
+
+	if n := server.Notify(twitch.ChannelBan, "kneissbot", event); n != 1 {
+		t.Fatalf("Notified %v sessions, want 1", n)
+	}
+
+	deadline := time.Now().Add(time.Second)
+
+	for {
+		channel.Management.mutex.Lock()
+		timeouts, moderations := channel.Management.Timeouts, channel.Management.Moderations["moderator"]
+		channel.Management.mutex.Unlock()
+
+		if moderations > 0 {
+			if timeouts != 2 {
+				t.Errorf("Counted %v timeouts, want 2", timeouts)
+			}
+
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("Ban not received from EventSub")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
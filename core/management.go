@@ -3,6 +3,8 @@ package core
 import (
 	"log"
 	"math"
+	"sort"
+	"sync"
 
 	watchmen "github.com/kookehs/watchmen/core"
 )
@@ -11,28 +13,35 @@ import (
 const DefaultModerators = 3
 
 // Management handles logic related to dynamically managing moderators.
+// Counters are incremented by the IRC and EventSub goroutines while the
+// update goroutine reads and resets them, so they are changed through
+// methods which lock the Management.
 type Management struct {
 	// Management variables
 	MovingAverage *MovingAverage
 
 	// Twitch related variables
-	Bans       int
-	Messages   uint64
-	Moderators int
-	Timeouts   int
+	Bans        int
+	Deletions   int
+	Messages    uint64
+	Moderations map[string]int
+	Moderators  int
+	Timeouts    int
 
 	// Watchmen modules
 	DPoS   *watchmen.DPoS
 	Ledger *watchmen.Ledger
 	Node   *watchmen.Node
+
+	mutex sync.Mutex
 }
 
-// NewManagement creates and initializes a new Management.
-func NewManagement(bot *Bot, username string) *Management {
+// NewManagement creates and initializes a new Management for the given channel.
+func NewManagement(channel *Channel, username string) *Management {
 	dpos := watchmen.NewDPoS()
 	ledger := watchmen.NewLedger()
 	ma := NewMovingAverage(10)
-	node := watchmen.NewNode(dpos, ledger, bot)
+	node := watchmen.NewNode(dpos, ledger, channel)
 
 	// TOOD: Figure out distribution model.
 	// NOTE: Distribute balance of genesis delegates.
@@ -49,6 +58,7 @@ func NewManagement(bot *Bot, username string) *Management {
 		DPoS:          dpos,
 		Ledger:        ledger,
 		Moderators:    DefaultModerators,
+		Moderations:   make(map[string]int),
 		MovingAverage: ma,
 		Node:          node,
 	}
@@ -107,14 +117,84 @@ func (m *Management) Score() float64 {
 	return score
 }
 
-// Update updates variables and resets counters.
+// Ban counts a ban, or a timeout unless permanent, and attributes it to
+// the given moderator if known.
+func (m *Management) Ban(moderator string, permanent bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if permanent {
+		m.Bans++
+	} else {
+		m.Timeouts++
+	}
+
+	if len(moderator) > 0 {
+		m.Moderations[moderator]++
+	}
+}
+
+// Delete counts a deleted chat message.
+func (m *Management) Delete() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Deletions++
+}
+
+// Message counts a chat message.
+func (m *Management) Message() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Messages++
+}
+
+// Update updates variables and resets counters. The Management is locked
+// while the heuristic reads the counters.
+// NOTE: MaxForgers is shared by every channel. Channels are updated one at
+// a time so that it holds the value of the channel being updated.
 func (m *Management) Update() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	m.Moderators = m.Heuristic()
 	watchmen.MaxForgers = m.Moderators
-	log.Printf("[Management]: Messages - %v, Bans - %v, Timeouts - %v", m.Messages, m.Bans, m.Timeouts)
+	log.Printf("[Management]: Messages - %v, Bans - %v, Timeouts - %v, Deletions - %v", m.Messages, m.Bans, m.Timeouts, m.Deletions)
 	log.Printf("[Management]: Mods - %v", m.Moderators)
+	moderators := make([]string, 0, len(m.Moderations))
+
+	for moderator := range m.Moderations {
+		moderators = append(moderators, moderator)
+	}
+
+	sort.Strings(moderators)
+
+	for _, moderator := range moderators {
+		log.Printf("[Management]: %v - %v bans and timeouts", moderator, m.Moderations[moderator])
+	}
+
+	m.reset()
+}
+
+// Rebaseline discards the moving averages and counters so that the
+// heuristic starts over, such as when a stream starts after chat was idle.
+func (m *Management) Rebaseline() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.MovingAverage = NewMovingAverage(m.MovingAverage.Period)
+	m.reset()
+}
+
+// Reset resets the counters of the current interval.
+func (m *Management) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.reset()
+}
 
+// reset resets the counters while the Management is locked.
+func (m *Management) reset() {
 	m.Bans = 0
+	m.Deletions = 0
 	m.Messages = 0
+	m.Moderations = make(map[string]int)
 	m.Timeouts = 0
 }
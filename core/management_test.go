@@ -0,0 +1,56 @@
+package core
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/kookehs/kneissbot/net/api/twitch"
+)
+
+func TestManagementConcurrentEvents(t *testing.T) {
+	bot, err := NewReplayBot([]string{"kneissbot"}, t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channel := bot.Channel("kneissbot")
+	event := &twitch.BanEvent{IsPermanent: true}
+	event.BroadcasterUserLogin = "kneissbot"
+	event.ModeratorUserLogin = "moderator"
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// EventSub handlers run on their own goroutine while channels update.
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 1000; i++ {
+			Ban(bot, event)
+			MessageDelete(bot, &twitch.MessageDeleteEvent{EventBroadcaster: event.EventBroadcaster})
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 100; i++ {
+			channel.Management.Update()
+
+			if i%10 == 0 {
+				channel.Management.Rebaseline()
+			}
+		}
+	}()
+
+	wg.Wait()
+	channel.Management.Reset()
+	Ban(bot, event)
+	event.IsPermanent = false
+	Ban(bot, event)
+	management := channel.Management
+
+	if management.Bans != 1 || management.Timeouts != 1 || management.Moderations["moderator"] != 2 {
+		t.Errorf("counted %v bans, %v timeouts and %v moderations", management.Bans, management.Timeouts, management.Moderations["moderator"])
+	}
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// Presence tracks the users in the chat of a channel. It is seeded with a
+// snapshot of the chatters from the Twitch API and kept current with JOIN
+// and PART from the membership capability as well as chat messages.
+type Presence struct {
+	changed map[string]time.Time
+	mutex   sync.RWMutex
+	present map[string]bool
+	seen    map[string]time.Time
+}
+
+// NewPresence creates and initializes an empty Presence.
+func NewPresence() *Presence {
+	return &Presence{
+		changed: make(map[string]time.Time),
+		present: make(map[string]bool),
+		seen:    make(map[string]time.Time),
+	}
+}
+
+// Available returns whether or not the given user is in the chat.
+func (p *Presence) Available(username string) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.present[username]
+}
+
+// Join marks the user as present at the given time.
+func (p *Presence) Join(username string, t time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.changed[username] = t
+	p.present[username] = true
+	p.seen[username] = t
+}
+
+// LastSeen returns when the user was last known to be in the chat.
+func (p *Presence) LastSeen(username string) (time.Time, bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	t, ok := p.seen[username]
+	return t, ok
+}
+
+// Len returns the number of users in the chat.
+func (p *Presence) Len() int {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return len(p.present)
+}
+
+// Part marks the user as absent at the given time.
+func (p *Presence) Part(username string, t time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.changed[username] = t
+	delete(p.present, username)
+	p.seen[username] = t
+}
+
+// Snapshot replaces the users in the chat with those retrieved from the
+// Twitch API at the given time. Users who joined or parted since then
+// keep their state as the snapshot may not include the change.
+func (p *Presence) Snapshot(usernames []string, t time.Time) {
+	present := make(map[string]bool, len(usernames))
+
+	for _, username := range usernames {
+		present[username] = true
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for username, changed := range p.changed {
+		if !changed.After(t) {
+			delete(p.changed, username)
+			continue
+		}
+
+		if p.present[username] {
+			present[username] = true
+		} else {
+			delete(present, username)
+		}
+	}
+
+	for username := range present {
+		p.seen[username] = t
+	}
+
+	p.present = present
+}
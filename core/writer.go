@@ -0,0 +1,66 @@
+package core
+
+import (
+	"log"
+	"strings"
+
+	"github.com/kookehs/kneissbot/net/api/twitch"
+	"github.com/kookehs/kneissbot/net/irc"
+)
+
+// writerCapabilities are what writers need to report the outcome of
+// commands through tagged NOTICE messages.
+var writerCapabilities = []string{twitch.CommandsCapability, twitch.TagsCapability}
+
+// writer handles incoming messages of a write-only session of the Pool.
+// Writers do not join channels, so they only receive replies to their own
+// requests along with keepalive and reconnect messages. Capabilities are
+// negotiated by every writer on its own and kept apart from the bot's.
+type writer struct {
+	bot     *Bot
+	replies *irc.Correlator
+	session *irc.Session
+}
+
+// In answers PING and RECONNECT on the writer's own session and awaits the
+// replies to logging in and negotiating capabilities. Everything else is
+// passed to the bot so that replies reach their requests.
+func (w *writer) In(input []byte) {
+	message, err := irc.MakeMessage(string(input))
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if w.replies.Offer(message) {
+		return
+	}
+
+	switch {
+	case strings.Compare(message.Command, "CAP") == 0:
+		// Capabilities of the writer are not those of the bot.
+	case strings.Compare(message.Command, "PING") == 0:
+		w.session.Write("PONG :tmi.twitch.tv")
+	case strings.Compare(message.Command, "RECONNECT") == 0:
+		if err := w.session.Reconnect(); err != nil {
+			log.Println(err)
+		}
+	default:
+		w.bot.In(input)
+	}
+}
+
+// connect authenticates the writer and negotiates its capabilities.
+// The blocking operation returns whether connecting to IRC server was successful
+func (w *writer) connect() bool {
+	_, ok := w.bot.connect(w.session, w.replies, writerCapabilities)
+	return ok
+}
+
+// Reconnected authenticates the writer again after its session reconnected.
+func (w *writer) Reconnected() {
+	if ok := w.connect(); !ok {
+		log.Println("[Bot]: Unable to authenticate writer after reconnecting")
+	}
+}
@@ -2,12 +2,28 @@ package main
 
 import (
 	"bufio"
+	"flag"
+	"log"
 	"os"
+	"strings"
 
 	"github.com/kookehs/kneissbot/core"
+	"github.com/kookehs/kneissbot/net/irc"
 )
 
 func main() {
+	record := flag.String("record", "", "record inbound IRC lines to the given file")
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "auth":
+		auth(flag.Args()[1:])
+		return
+	case "replay":
+		replay(flag.Args()[1:])
+		return
+	}
+
 	bot, err := core.NewBot()
 
 	if err != nil {
@@ -15,6 +31,18 @@ func main() {
 	}
 
 	defer bot.Close()
+
+	if len(*record) > 0 {
+		file, err := os.OpenFile(*record, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+
+		if err != nil {
+			panic(err)
+		}
+
+		defer file.Close()
+		bot.Session.Recorder = irc.NewRecorder(file)
+	}
+
 	bot.Start()
 
 	if ok := bot.Connect(); !ok {
@@ -23,16 +51,65 @@ func main() {
 
 	// TODO: Remove test code
 	reader := bufio.NewReader(os.Stdin)
-	channel, err := reader.ReadString('\n')
+	channels, err := reader.ReadString('\n')
 
 	if err != nil {
 		panic(err)
 	}
 
-	if ok := bot.Join(channel); !ok {
-		panic("Unable to join IRC channel")
+	for _, channel := range strings.Fields(channels) {
+		if ok := bot.Join(channel); !ok {
+			panic("Unable to join IRC channel " + channel)
+		}
 	}
 
-	bot.Cap(nil)
 	select {}
 }
+
+// auth authorizes the bot with Twitch and stores the token without starting it.
+// Usage: kneissbot auth [--device]
+func auth(args []string) {
+	flags := flag.NewFlagSet("auth", flag.ExitOnError)
+	device := flags.Bool("device", false, "print a code to enter on another device instead of opening a browser")
+	flags.Parse(args)
+
+	if err := core.Authorize(*device); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("[Bot]: Authorized")
+}
+
+// replay runs a recorded session through a bot without a network connection.
+// Usage: kneissbot replay [-channels names] [-dir path] [-speed n] file
+func replay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	channels := flags.String("channels", "", "comma separated channels the recording was made in")
+	dir := flags.String("dir", os.TempDir()+"/kneissbot-replay", "directory to store replayed state in")
+	speed := flags.Float64("speed", 0, "replay speed where 1 is real time and 0 is instant")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || len(*channels) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+
+	if err != nil {
+		panic(err)
+	}
+
+	defer file.Close()
+	bot, err := core.NewReplayBot(strings.Split(*channels, ","), *dir)
+
+	if err != nil {
+		panic(err)
+	}
+
+	defer bot.Close()
+
+	if err := bot.Replay(file, *speed); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,43 @@
+package twitch
+
+import (
+	"context"
+	"net/url"
+)
+
+const (
+	// Followers is the Helix endpoint for retrieving followers of a channel
+	Followers = "/channels/followers"
+	// Streams is the Helix endpoint for retrieving live streams
+	Streams = "/streams"
+	// Subscriptions is the Helix endpoint for retrieving subscribers of a channel
+	Subscriptions = "/subscriptions"
+)
+
+// Followers returns an Iterator over the followers of the broadcaster's
+// channel. If a user ID is given, only that user is returned if following.
+func (a *API) Followers(ctx context.Context, broadcasterID, userID string) *Iterator[FollowerResponse] {
+	query := make(url.Values)
+	query.Add("broadcaster_id", broadcasterID)
+
+	if len(userID) > 0 {
+		query.Add("user_id", userID)
+	}
+
+	return NewIterator[FollowerResponse](ctx, a, Followers, true, query)
+}
+
+// Subscriptions returns an Iterator over the subscriptions to the
+// broadcaster's channel. If user IDs are given, only those of them who
+// are subscribed are returned.
+func (a *API) Subscriptions(ctx context.Context, broadcasterID string, userID []string) *Iterator[SubscriptionResponse] {
+	query := make(url.Values)
+	query.Add("broadcaster_id", broadcasterID)
+	return NewIterator[SubscriptionResponse](ctx, a, Subscriptions, true, Batches(query, "user_id", userID)...)
+}
+
+// Streams returns an Iterator over the live streams of the given users.
+// Users who are offline are left out.
+func (a *API) Streams(ctx context.Context, userLogin []string) *Iterator[StreamResponse] {
+	return NewIterator[StreamResponse](ctx, a, Streams, true, Batches(make(url.Values), "user_login", userLogin)...)
+}
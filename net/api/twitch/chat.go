@@ -0,0 +1,19 @@
+package twitch
+
+import (
+	"context"
+	"net/url"
+)
+
+// Chatters is the Helix endpoint for retrieving the users in a chat
+const Chatters = "/chat/chatters"
+
+// Chatters returns an Iterator over the users in the chat of the
+// broadcaster's channel. The moderator must be the broadcaster or one of
+// their moderators.
+func (a *API) Chatters(ctx context.Context, broadcasterID, moderatorID string) *Iterator[ChatterResponse] {
+	query := make(url.Values)
+	query.Add("broadcaster_id", broadcasterID)
+	query.Add("moderator_id", moderatorID)
+	return NewIterator[ChatterResponse](ctx, a, Chatters, true, query)
+}
@@ -0,0 +1,103 @@
+package twitch
+
+// EventBroadcaster contains the broadcaster of the channel an EventSub
+// event occurred in.
+type EventBroadcaster struct {
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+}
+
+// EventModerator contains the moderator who caused an EventSub event.
+type EventModerator struct {
+	ModeratorUserID    string `json:"moderator_user_id"`
+	ModeratorUserLogin string `json:"moderator_user_login"`
+	ModeratorUserName  string `json:"moderator_user_name"`
+}
+
+// EventUser contains the user an EventSub event is about.
+type EventUser struct {
+	UserID    string `json:"user_id"`
+	UserLogin string `json:"user_login"`
+	UserName  string `json:"user_name"`
+}
+
+// BanEvent is the event of a channel.ban notification. A user who is
+// not banned permanently was timed out until EndsAt.
+type BanEvent struct {
+	EventBroadcaster
+	EventModerator
+	EventUser
+	BannedAt    string `json:"banned_at"`
+	EndsAt      string `json:"ends_at"`
+	IsPermanent bool   `json:"is_permanent"`
+	Reason      string `json:"reason"`
+}
+
+// MessageDeleteEvent is the event of a channel.chat.message_delete
+// notification.
+type MessageDeleteEvent struct {
+	EventBroadcaster
+	MessageID       string `json:"message_id"`
+	TargetUserID    string `json:"target_user_id"`
+	TargetUserLogin string `json:"target_user_login"`
+	TargetUserName  string `json:"target_user_name"`
+}
+
+// ModeratorEvent is the event of a channel.moderator.add or
+// channel.moderator.remove notification.
+type ModeratorEvent struct {
+	EventBroadcaster
+	EventUser
+}
+
+// RaidEvent is the event of a channel.raid notification.
+type RaidEvent struct {
+	FromBroadcasterUserID    string `json:"from_broadcaster_user_id"`
+	FromBroadcasterUserLogin string `json:"from_broadcaster_user_login"`
+	FromBroadcasterUserName  string `json:"from_broadcaster_user_name"`
+	ToBroadcasterUserID      string `json:"to_broadcaster_user_id"`
+	ToBroadcasterUserLogin   string `json:"to_broadcaster_user_login"`
+	ToBroadcasterUserName    string `json:"to_broadcaster_user_name"`
+	Viewers                  int    `json:"viewers"`
+}
+
+// RedemptionEvent is the event of a
+// channel.channel_points_custom_reward_redemption.add notification.
+type RedemptionEvent struct {
+	EventBroadcaster
+	EventUser
+	ID         string `json:"id"`
+	RedeemedAt string `json:"redeemed_at"`
+	Reward     Reward `json:"reward"`
+	Status     string `json:"status"`
+	UserInput  string `json:"user_input"`
+}
+
+// Reward contains the custom reward a user redeemed channel points for.
+type Reward struct {
+	Cost   int    `json:"cost"`
+	ID     string `json:"id"`
+	Prompt string `json:"prompt"`
+	Title  string `json:"title"`
+}
+
+// StreamOfflineEvent is the event of a stream.offline notification.
+type StreamOfflineEvent struct {
+	EventBroadcaster
+}
+
+// StreamOnlineEvent is the event of a stream.online notification.
+type StreamOnlineEvent struct {
+	EventBroadcaster
+	ID        string `json:"id"`
+	StartedAt string `json:"started_at"`
+	Type      string `json:"type"`
+}
+
+// UnbanEvent is the event of a channel.unban notification.
+type UnbanEvent struct {
+	EventBroadcaster
+	EventModerator
+	EventUser
+}
@@ -0,0 +1,565 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// EventSubSubscriptions is the Helix endpoint for creating and deleting EventSub subscriptions
+	EventSubSubscriptions = "/eventsub/subscriptions"
+	// WebsocketMethod is the transport method of subscriptions sent over a websocket session
+	WebsocketMethod = "websocket"
+
+	// EventSub message types
+	KeepaliveMessage    = "session_keepalive"
+	NotificationMessage = "notification"
+	ReconnectMessage    = "session_reconnect"
+	RevocationMessage   = "revocation"
+	WelcomeMessage      = "session_welcome"
+
+	// EventSub subscription types
+	ChannelBan                 = "channel.ban"
+	ChannelChatMessageDelete   = "channel.chat.message_delete"
+	ChannelModeratorAdd        = "channel.moderator.add"
+	ChannelModeratorRemove     = "channel.moderator.remove"
+	ChannelPointsRedemptionAdd = "channel.channel_points_custom_reward_redemption.add"
+	ChannelRaid                = "channel.raid"
+	ChannelUnban               = "channel.unban"
+	StreamOffline              = "stream.offline"
+	StreamOnline               = "stream.online"
+)
+
+var (
+	// ErrNotWelcomed is returned when the EventSub server does not start a
+	// session with a welcome message.
+	ErrNotWelcomed = errors.New("EventSub session not welcomed")
+	// KeepaliveMargin is the time waited past the keepalive timeout of a
+	// session before the connection is considered dead.
+	KeepaliveMargin = 5 * time.Second
+	// MessageHistory is the number of message IDs remembered to drop
+	// notifications delivered more than once.
+	MessageHistory = 100
+	// WelcomeTimeout is the time to wait for the welcome message of a session.
+	WelcomeTimeout = 10 * time.Second
+)
+
+// EventSubMessage is the JSON structure sent by the EventSub server.
+type EventSubMessage struct {
+	Metadata EventSubMetadata `json:"metadata"`
+	Payload  EventSubPayload  `json:"payload"`
+}
+
+// EventSubMetadata identifies an EventSub message and its type. The
+// subscription type and version are only set for notifications and
+// revocations.
+type EventSubMetadata struct {
+	MessageID           string `json:"message_id"`
+	MessageTimestamp    string `json:"message_timestamp"`
+	MessageType         string `json:"message_type"`
+	SubscriptionType    string `json:"subscription_type,omitempty"`
+	SubscriptionVersion string `json:"subscription_version,omitempty"`
+}
+
+// EventSubPayload contains the session of welcome and reconnect messages
+// or the subscription and event of notifications and revocations.
+type EventSubPayload struct {
+	Event        json.RawMessage               `json:"event,omitempty"`
+	Session      *EventSubSession              `json:"session,omitempty"`
+	Subscription *EventSubSubscriptionResponse `json:"subscription,omitempty"`
+}
+
+// EventSubSession contains variables related to a websocket session.
+// ReconnectURL is only set when the server asks the client to reconnect.
+type EventSubSession struct {
+	ConnectedAt             string `json:"connected_at"`
+	ID                      string `json:"id"`
+	KeepaliveTimeoutSeconds int    `json:"keepalive_timeout_seconds"`
+	ReconnectURL            string `json:"reconnect_url"`
+	Status                  string `json:"status"`
+}
+
+// Subscription is an EventSub subscription to events of the given type
+// which match the condition.
+type Subscription struct {
+	Condition map[string]string
+	Type      string
+	Version   string
+}
+
+// key identifies the subscription regardless of the order of its condition.
+func (s Subscription) key() string {
+	conditions := make([]string, 0, len(s.Condition))
+
+	for k, v := range s.Condition {
+		if len(v) > 0 {
+			conditions = append(conditions, k+"="+v)
+		}
+	}
+
+	sort.Strings(conditions)
+	return s.Type + "/" + s.Version + "?" + strings.Join(conditions, "&")
+}
+
+// EventSub is a client of EventSub over a websocket. Subscriptions are
+// kept by the client and created for every new session. They carry over
+// when the server asks the client to reconnect. Notifications are decoded
+// into typed events and passed to the handlers subscribed with the On
+// methods.
+type EventSub struct {
+	API    *API
+	Origin string
+	URL    string
+
+	conn          *websocket.Conn
+	handlers      map[string][]func(json.RawMessage)
+	history       []string
+	ids           map[string]string
+	mutex         sync.Mutex
+	seen          map[string]bool
+	session       string
+	subscriptions map[string]Subscription
+}
+
+// NewEventSub creates and initializes an EventSub connecting to the
+// EventSub URL of the given API, which is used to create subscriptions.
+func NewEventSub(api *API) *EventSub {
+	return &EventSub{
+		API:           api,
+		Origin:        Origin,
+		URL:           api.EventSubURL,
+		handlers:      make(map[string][]func(json.RawMessage)),
+		ids:           make(map[string]string),
+		seen:          make(map[string]bool),
+		subscriptions: make(map[string]Subscription),
+	}
+}
+
+// CreateEventSubSubscription subscribes to the event of the request.
+func (a *API) CreateEventSubSubscription(ctx context.Context, request EventSubSubscriptionRequest) (*EventSubSubscriptionsResponse, error) {
+	body, err := a.Do(ctx, http.MethodPost, a.HelixURL+EventSubSubscriptions, request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp := new(EventSubSubscriptionsResponse)
+
+	if err = json.Unmarshal(body, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// DeleteEventSubSubscription deletes the subscription with the given ID.
+func (a *API) DeleteEventSubSubscription(ctx context.Context, id string) error {
+	query := make(url.Values)
+	query.Add("id", id)
+	_, err := a.Do(ctx, http.MethodDelete, a.HelixURL+EventSubSubscriptions+"?"+query.Encode(), nil)
+	return err
+}
+
+// Connected returns whether or not a session is established.
+func (es *EventSub) Connected() bool {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	return len(es.session) > 0
+}
+
+// Subscribed returns whether or not the subscription was created in the
+// current session.
+func (es *EventSub) Subscribed(subscription Subscription) bool {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	_, ok := es.ids[subscription.key()]
+	return ok
+}
+
+// OnBan subscribes a handler to channel.ban events.
+func (es *EventSub) OnBan(handler func(*BanEvent)) {
+	on(es, ChannelBan, handler)
+}
+
+// OnMessageDelete subscribes a handler to channel.chat.message_delete events.
+func (es *EventSub) OnMessageDelete(handler func(*MessageDeleteEvent)) {
+	on(es, ChannelChatMessageDelete, handler)
+}
+
+// OnModeratorAdd subscribes a handler to channel.moderator.add events.
+func (es *EventSub) OnModeratorAdd(handler func(*ModeratorEvent)) {
+	on(es, ChannelModeratorAdd, handler)
+}
+
+// OnModeratorRemove subscribes a handler to channel.moderator.remove events.
+func (es *EventSub) OnModeratorRemove(handler func(*ModeratorEvent)) {
+	on(es, ChannelModeratorRemove, handler)
+}
+
+// OnRaid subscribes a handler to channel.raid events.
+func (es *EventSub) OnRaid(handler func(*RaidEvent)) {
+	on(es, ChannelRaid, handler)
+}
+
+// OnRedemption subscribes a handler to channel points redemption events.
+func (es *EventSub) OnRedemption(handler func(*RedemptionEvent)) {
+	on(es, ChannelPointsRedemptionAdd, handler)
+}
+
+// OnStreamOffline subscribes a handler to stream.offline events.
+func (es *EventSub) OnStreamOffline(handler func(*StreamOfflineEvent)) {
+	on(es, StreamOffline, handler)
+}
+
+// OnStreamOnline subscribes a handler to stream.online events.
+func (es *EventSub) OnStreamOnline(handler func(*StreamOnlineEvent)) {
+	on(es, StreamOnline, handler)
+}
+
+// OnUnban subscribes a handler to channel.unban events.
+func (es *EventSub) OnUnban(handler func(*UnbanEvent)) {
+	on(es, ChannelUnban, handler)
+}
+
+// on subscribes a handler to the events of the given subscription type
+// which are decoded into T.
+func on[T any](es *EventSub, subscriptionType string, handler func(*T)) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	es.handlers[subscriptionType] = append(es.handlers[subscriptionType], func(data json.RawMessage) {
+		event := new(T)
+
+		if err := json.Unmarshal(data, event); err != nil {
+			log.Println(err)
+			return
+		}
+
+		handler(event)
+	})
+}
+
+// Run connects to EventSub and dispatches notifications until the context
+// is done. Whenever the connection fails, such as when no keepalive
+// arrived in time, a new session is started after a delay from Backoff.
+func (es *EventSub) Run(ctx context.Context) error {
+	for attempt := 0; ; attempt++ {
+		conn, session, err := es.dial(ctx, es.URL)
+
+		if err == nil {
+			attempt = 0
+			es.connect(conn, session.ID)
+			es.subscribeAll(ctx)
+			err = es.listen(ctx, conn, session)
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		log.Println("[EventSub]: Disconnected -", err)
+
+		if err := sleep(ctx, Backoff(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// Subscribe keeps the subscriptions for every future session and creates
+// them in the current session, if any. The first error is returned.
+func (es *EventSub) Subscribe(ctx context.Context, subscriptions ...Subscription) error {
+	es.mutex.Lock()
+
+	for _, subscription := range subscriptions {
+		es.subscriptions[subscription.key()] = subscription
+	}
+
+	es.mutex.Unlock()
+	var result error
+
+	for _, subscription := range subscriptions {
+		if err := es.create(ctx, subscription); err != nil && result == nil {
+			result = err
+		}
+	}
+
+	return result
+}
+
+// Unsubscribe stops keeping the subscriptions and deletes those created in
+// the current session. The first error is returned.
+func (es *EventSub) Unsubscribe(ctx context.Context, subscriptions ...Subscription) error {
+	ids := make([]string, 0, len(subscriptions))
+	es.mutex.Lock()
+
+	for _, subscription := range subscriptions {
+		key := subscription.key()
+		delete(es.subscriptions, key)
+
+		if id, ok := es.ids[key]; ok {
+			delete(es.ids, key)
+			ids = append(ids, id)
+		}
+	}
+
+	es.mutex.Unlock()
+	var result error
+
+	for _, id := range ids {
+		if err := es.API.DeleteEventSubSubscription(ctx, id); err != nil && result == nil {
+			result = err
+		}
+	}
+
+	return result
+}
+
+// connect makes the connection current, closing the previous one. The
+// subscriptions created are forgotten when the session changes.
+func (es *EventSub) connect(conn *websocket.Conn, session string) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	if es.conn != nil && es.conn != conn {
+		es.conn.Close()
+	}
+
+	if strings.Compare(es.session, session) != 0 {
+		es.ids = make(map[string]string)
+	}
+
+	es.conn = conn
+	es.session = session
+}
+
+// create creates the subscription in the current session unless it was
+// created already.
+func (es *EventSub) create(ctx context.Context, subscription Subscription) error {
+	key := subscription.key()
+	es.mutex.Lock()
+	session := es.session
+	_, ok := es.ids[key]
+	es.mutex.Unlock()
+
+	if len(session) == 0 || ok {
+		return nil
+	}
+
+	resp, err := es.API.CreateEventSubSubscription(ctx, EventSubSubscriptionRequest{
+		Condition: subscription.Condition,
+		Transport: EventSubTransport{Method: WebsocketMethod, SessionID: session},
+		Type:      subscription.Type,
+		Version:   subscription.Version,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	if strings.Compare(es.session, session) == 0 && len(resp.Data) > 0 {
+		es.ids[key] = resp.Data[0].ID
+	}
+
+	return nil
+}
+
+// dial connects to the given URL and waits for the welcome message.
+func (es *EventSub) dial(ctx context.Context, url string) (*websocket.Conn, *EventSubSession, error) {
+	config, err := websocket.NewConfig(url, es.Origin)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := config.DialContext(ctx)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	message, err := receive(conn, WelcomeTimeout)
+
+	if err == nil && (message.Metadata.MessageType != WelcomeMessage || message.Payload.Session == nil) {
+		err = ErrNotWelcomed
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, message.Payload.Session, nil
+}
+
+// disconnect closes the current connection and ends the session.
+func (es *EventSub) disconnect() {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	if es.conn != nil {
+		es.conn.Close()
+		es.conn = nil
+	}
+
+	es.ids = make(map[string]string)
+	es.session = ""
+}
+
+// dispatch calls every handler subscribed to the type of the notification.
+func (es *EventSub) dispatch(message *EventSubMessage) {
+	es.mutex.Lock()
+	handlers := es.handlers[message.Metadata.SubscriptionType]
+	es.mutex.Unlock()
+
+	for _, handler := range handlers {
+		handler(message.Payload.Event)
+	}
+}
+
+// duplicate returns whether or not a message with the given ID was
+// received before and remembers the ID otherwise.
+func (es *EventSub) duplicate(id string) bool {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	if es.seen[id] {
+		return true
+	}
+
+	es.seen[id] = true
+	es.history = append(es.history, id)
+
+	if len(es.history) > MessageHistory {
+		delete(es.seen, es.history[0])
+		es.history = es.history[1:]
+	}
+
+	return false
+}
+
+// listen reads messages until the connection fails or the context is
+// done. The connection is replaced when the server asks the client to
+// reconnect, keeping the session and its subscriptions. Subscriptions are
+// created again if the new connection was welcomed to another session.
+func (es *EventSub) listen(ctx context.Context, conn *websocket.Conn, session *EventSubSession) error {
+	done := make(chan struct{})
+	defer close(done)
+	defer es.disconnect()
+
+	// Reads only return early once the connection is closed.
+	go func() {
+		select {
+		case <-ctx.Done():
+			es.disconnect()
+		case <-done:
+		}
+	}()
+
+	for {
+		message, err := receive(conn, keepalive(session))
+
+		if err != nil {
+			return err
+		}
+
+		switch message.Metadata.MessageType {
+		case NotificationMessage:
+			if !es.duplicate(message.Metadata.MessageID) {
+				es.dispatch(message)
+			}
+		case ReconnectMessage:
+			if message.Payload.Session == nil {
+				continue
+			}
+
+			next, welcome, err := es.dial(ctx, message.Payload.Session.ReconnectURL)
+
+			if err != nil {
+				return err
+			}
+
+			// The previous connection is closed once the new one was welcomed.
+			es.connect(next, welcome.ID)
+			es.subscribeAll(ctx)
+			conn, session = next, welcome
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		case RevocationMessage:
+			if message.Payload.Subscription != nil {
+				es.revoke(message.Payload.Subscription)
+			}
+		}
+	}
+}
+
+// revoke forgets a subscription revoked by Twitch, such as when the user
+// removed the authorization of the app.
+func (es *EventSub) revoke(resp *EventSubSubscriptionResponse) {
+	log.Printf("[EventSub]: Subscription revoked - %v (%v)", resp.Type, resp.Status)
+	subscription := Subscription{Condition: resp.Condition, Type: resp.Type, Version: resp.Version}
+	key := subscription.key()
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	delete(es.ids, key)
+	delete(es.subscriptions, key)
+}
+
+// subscribeAll creates every kept subscription in the current session.
+func (es *EventSub) subscribeAll(ctx context.Context) {
+	es.mutex.Lock()
+	subscriptions := make([]Subscription, 0, len(es.subscriptions))
+
+	for _, subscription := range es.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	es.mutex.Unlock()
+
+	for _, subscription := range subscriptions {
+		if err := es.create(ctx, subscription); err != nil {
+			log.Printf("[EventSub]: Unable to subscribe to %v - %v", subscription.Type, err)
+		}
+	}
+}
+
+// keepalive returns how long the session may be silent before the
+// connection is considered dead.
+func keepalive(session *EventSubSession) time.Duration {
+	timeout := time.Duration(session.KeepaliveTimeoutSeconds) * time.Second
+
+	if timeout <= 0 {
+		timeout = WelcomeTimeout
+	}
+
+	return timeout + KeepaliveMargin
+}
+
+// receive reads a single message, failing if none arrives in time.
+func receive(conn *websocket.Conn, timeout time.Duration) (*EventSubMessage, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	message := new(EventSubMessage)
+
+	if err := websocket.JSON.Receive(conn, message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
@@ -0,0 +1,259 @@
+package twitch_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kookehs/kneissbot/net/api/twitch"
+	"github.com/kookehs/kneissbot/net/api/twitch/twitchtest"
+)
+
+// startEventSub runs an EventSub client of the given channel's token
+// against the server until the test ends.
+func startEventSub(t *testing.T, server *twitchtest.Server, channel string) *twitch.EventSub {
+	server.AddToken("token", "", channel, 0)
+	es := twitch.NewEventSub(twitch.NewAPI("token", server.Options()...))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- es.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+
+		if err := <-done; err != context.Canceled {
+			t.Errorf("Run returned %v", err)
+		}
+	})
+
+	eventually(t, "connect", es.Connected)
+	return es
+}
+
+// eventually fails the test unless the condition holds within a second.
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Unable to %v in time", what)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// ban returns a channel.ban subscription to the channel with the given ID.
+func ban(id string) twitch.Subscription {
+	return twitch.Subscription{
+		Condition: map[string]string{"broadcaster_user_id": id},
+		Type:      twitch.ChannelBan,
+		Version:   "1",
+	}
+}
+
+// bans returns a channel of the moderators of received channel.ban events.
+func bans(es *twitch.EventSub) <-chan string {
+	moderators := make(chan string, 16)
+	es.OnBan(func(event *twitch.BanEvent) {
+		moderators <- event.ModeratorUserLogin
+	})
+
+	return moderators
+}
+
+// notifyBan sends a channel.ban notification of the given moderator.
+func notifyBan(server *twitchtest.Server, channel, moderator string) int {
+	event := twitch.BanEvent{IsPermanent: true}
+	event.BroadcasterUserLogin = channel
+	event.ModeratorUserLogin = moderator
+	return server.Notify(twitch.ChannelBan, channel, event)
+}
+
+func TestEventSubSubscribe(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	id := server.AddUser("kneissbot")
+	es := startEventSub(t, server, "kneissbot")
+	subscription := ban(id)
+
+	if err := es.Subscribe(context.Background(), subscription); err != nil {
+		t.Fatal(err)
+	}
+
+	if !es.Subscribed(subscription) {
+		t.Error("Subscription not created in the welcomed session")
+	}
+
+	if got, want := server.Subscriptions("kneissbot"), []string{twitch.ChannelBan}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Server has subscriptions %v, want %v", got, want)
+	}
+
+	if err := es.Unsubscribe(context.Background(), subscription); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := server.Subscriptions("kneissbot"); len(got) > 0 {
+		t.Errorf("Server kept subscriptions %v", got)
+	}
+}
+
+func TestEventSubSubscribeOnWelcome(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	subscription := ban(server.AddUser("kneissbot"))
+	server.AddToken("token", "", "kneissbot", 0)
+	es := twitch.NewEventSub(twitch.NewAPI("token", server.Options()...))
+
+	// Subscriptions kept before connecting are created once welcomed.
+	if err := es.Subscribe(context.Background(), subscription); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go es.Run(ctx)
+	eventually(t, "subscribe", func() bool { return es.Subscribed(subscription) })
+
+	if got := server.Subscriptions("kneissbot"); !reflect.DeepEqual(got, []string{twitch.ChannelBan}) {
+		t.Errorf("Server has subscriptions %v", got)
+	}
+}
+
+func TestEventSubDispatch(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	es := startEventSub(t, server, "kneissbot")
+	moderators := bans(es)
+	unbans := make(chan string, 1)
+	es.OnUnban(func(event *twitch.UnbanEvent) {
+		unbans <- event.UserLogin
+	})
+
+	if err := es.Subscribe(context.Background(), ban(server.AddUser("kneissbot"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := notifyBan(server, "kneissbot", "alice"); n != 1 {
+		t.Fatalf("Notified %v sessions, want 1", n)
+	}
+
+	select {
+	case moderator := <-moderators:
+		if moderator != "alice" {
+			t.Errorf("Ban by %q, want alice", moderator)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Ban not dispatched")
+	}
+
+	// Handlers only receive events of the subscription type they were subscribed to.
+	select {
+	case user := <-unbans:
+		t.Errorf("Unban of %v dispatched for a ban", user)
+	default:
+	}
+}
+
+func TestEventSubDuplicate(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.SetDeliveries(3)
+	es := startEventSub(t, server, "kneissbot")
+	moderators := bans(es)
+
+	if err := es.Subscribe(context.Background(), ban(server.AddUser("kneissbot"))); err != nil {
+		t.Fatal(err)
+	}
+
+	notifyBan(server, "kneissbot", "alice")
+	notifyBan(server, "kneissbot", "bob")
+	got := make([]string, 0)
+	timeout := time.After(200 * time.Millisecond)
+
+	for done := false; !done; {
+		select {
+		case moderator := <-moderators:
+			got = append(got, moderator)
+		case <-timeout:
+			done = true
+		}
+	}
+
+	if want := []string{"alice", "bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Dispatched bans by %v, want %v", got, want)
+	}
+}
+
+func TestEventSubReconnect(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	es := startEventSub(t, server, "kneissbot")
+	moderators := bans(es)
+	subscription := ban(server.AddUser("kneissbot"))
+
+	if err := es.Subscribe(context.Background(), subscription); err != nil {
+		t.Fatal(err)
+	}
+
+	server.ReconnectEventSub()
+
+	// Notifications reach the client once the new connection took the session over.
+	eventually(t, "receive a ban after reconnecting", func() bool {
+		notifyBan(server, "kneissbot", "alice")
+
+		select {
+		case <-moderators:
+			return true
+		case <-time.After(20 * time.Millisecond):
+			return false
+		}
+	})
+
+	if !es.Connected() || !es.Subscribed(subscription) {
+		t.Error("Session or subscription lost during the handover")
+	}
+
+	if got := server.Subscriptions("kneissbot"); !reflect.DeepEqual(got, []string{twitch.ChannelBan}) {
+		t.Errorf("Server has subscriptions %v after the handover", got)
+	}
+}
+
+func TestEventSubReconnectNewSession(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	es := startEventSub(t, server, "kneissbot")
+	moderators := bans(es)
+	subscription := ban(server.AddUser("kneissbot"))
+
+	if err := es.Subscribe(context.Background(), subscription); err != nil {
+		t.Fatal(err)
+	}
+
+	server.MoveEventSub()
+
+	// Subscriptions are created again for the session the client was moved to.
+	eventually(t, "receive a ban in the new session", func() bool {
+		notifyBan(server, "kneissbot", "alice")
+
+		select {
+		case <-moderators:
+			return true
+		case <-time.After(20 * time.Millisecond):
+			return false
+		}
+	})
+
+	if !es.Subscribed(subscription) {
+		t.Error("Subscription not created in the new session")
+	}
+
+	if got := server.Subscriptions("kneissbot"); !reflect.DeepEqual(got, []string{twitch.ChannelBan}) {
+		t.Errorf("Server has subscriptions %v after moving the session", got)
+	}
+}
@@ -0,0 +1,131 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// BannedUsers is the Helix endpoint for retrieving banned users
+	BannedUsers = "/moderation/banned"
+	// Bans is the Helix endpoint for banning and unbanning users
+	Bans = "/moderation/bans"
+	// ChatMessages is the Helix endpoint for deleting chat messages
+	ChatMessages = "/moderation/chat"
+	// Moderators is the Helix endpoint for retrieving, adding and removing moderators
+	Moderators = "/moderation/moderators"
+	// VIPs is the Helix endpoint for adding and removing VIPs
+	VIPs = "/channels/vips"
+)
+
+// AddModerator adds the user to the moderators of the broadcaster's channel.
+func (a *API) AddModerator(ctx context.Context, broadcasterID, userID string) error {
+	query := make(url.Values)
+	query.Add("broadcaster_id", broadcasterID)
+	query.Add("user_id", userID)
+	_, err := a.Do(ctx, http.MethodPost, a.HelixURL+Moderators+"?"+query.Encode(), nil)
+	return err
+}
+
+// AddVIP adds the user to the VIPs of the broadcaster's channel.
+func (a *API) AddVIP(ctx context.Context, broadcasterID, userID string) error {
+	query := make(url.Values)
+	query.Add("broadcaster_id", broadcasterID)
+	query.Add("user_id", userID)
+	_, err := a.Do(ctx, http.MethodPost, a.HelixURL+VIPs+"?"+query.Encode(), nil)
+	return err
+}
+
+// BanUser bans the user from the broadcaster's channel on behalf of the
+// moderator. A positive duration in seconds times the user out instead.
+func (a *API) BanUser(ctx context.Context, broadcasterID, moderatorID string, ban BanRequest) (*BansResponse, error) {
+	query := make(url.Values)
+	query.Add("broadcaster_id", broadcasterID)
+	query.Add("moderator_id", moderatorID)
+	payload := map[string]BanRequest{"data": ban}
+	body, err := a.Do(ctx, http.MethodPost, a.HelixURL+Bans+"?"+query.Encode(), payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp := new(BansResponse)
+
+	if err = json.Unmarshal(body, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// DeleteChatMessages deletes the message with the given ID from the
+// broadcaster's chat on behalf of the moderator. All messages are
+// deleted if no ID is given.
+func (a *API) DeleteChatMessages(ctx context.Context, broadcasterID, moderatorID, messageID string) error {
+	query := make(url.Values)
+	query.Add("broadcaster_id", broadcasterID)
+	query.Add("moderator_id", moderatorID)
+
+	if len(messageID) > 0 {
+		query.Add("message_id", messageID)
+	}
+
+	_, err := a.Do(ctx, http.MethodDelete, a.HelixURL+ChatMessages+"?"+query.Encode(), nil)
+	return err
+}
+
+// BannedUsers returns an Iterator over the users banned from the
+// broadcaster's channel. If user IDs are given, only those of them who
+// are banned are returned.
+func (a *API) BannedUsers(ctx context.Context, broadcasterID string, userID []string) *Iterator[BannedUserResponse] {
+	query := make(url.Values)
+	query.Add("broadcaster_id", broadcasterID)
+	return NewIterator[BannedUserResponse](ctx, a, BannedUsers, true, Batches(query, "user_id", userID)...)
+}
+
+// GetModerators returns the moderators of the broadcaster's channel.
+// If user IDs are given, only those of them who are moderators are returned.
+func (a *API) GetModerators(ctx context.Context, broadcasterID string, userID []string) ([]ModeratorResponse, error) {
+	return Collect(a.Moderators(ctx, broadcasterID, userID))
+}
+
+// Moderators returns an Iterator over the moderators of the broadcaster's
+// channel. If user IDs are given, only those of them who are moderators
+// are returned.
+func (a *API) Moderators(ctx context.Context, broadcasterID string, userID []string) *Iterator[ModeratorResponse] {
+	query := make(url.Values)
+	query.Add("broadcaster_id", broadcasterID)
+	return NewIterator[ModeratorResponse](ctx, a, Moderators, true, Batches(query, "user_id", userID)...)
+}
+
+// RemoveModerator removes the user from the moderators of the broadcaster's
+// channel.
+func (a *API) RemoveModerator(ctx context.Context, broadcasterID, userID string) error {
+	query := make(url.Values)
+	query.Add("broadcaster_id", broadcasterID)
+	query.Add("user_id", userID)
+	_, err := a.Do(ctx, http.MethodDelete, a.HelixURL+Moderators+"?"+query.Encode(), nil)
+	return err
+}
+
+// RemoveVIP removes the user from the VIPs of the broadcaster's channel.
+func (a *API) RemoveVIP(ctx context.Context, broadcasterID, userID string) error {
+	query := make(url.Values)
+	query.Add("broadcaster_id", broadcasterID)
+	query.Add("user_id", userID)
+	_, err := a.Do(ctx, http.MethodDelete, a.HelixURL+VIPs+"?"+query.Encode(), nil)
+	return err
+}
+
+// UnbanUser removes a ban or timeout of the user in the broadcaster's
+// channel on behalf of the moderator.
+func (a *API) UnbanUser(ctx context.Context, broadcasterID, moderatorID, userID string) error {
+	query := make(url.Values)
+	query.Add("broadcaster_id", broadcasterID)
+	query.Add("moderator_id", moderatorID)
+	query.Add("user_id", userID)
+	_, err := a.Do(ctx, http.MethodDelete, a.HelixURL+Bans+"?"+query.Encode(), nil)
+	return err
+}
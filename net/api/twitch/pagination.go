@@ -0,0 +1,171 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+var (
+	// BatchSize is the most IDs or logins Helix accepts in a single request.
+	BatchSize = 100
+	// PageSize is the number of results requested per page.
+	PageSize = 100
+)
+
+// Page is a single page of a cursor-paginated Helix response.
+type Page[T any] struct {
+	Data       []T                `json:"data"`
+	Pagination PaginationResponse `json:"pagination"`
+}
+
+// Iterator streams the results of a Helix list endpoint one page at a
+// time. Each query is sent in turn and its cursor followed until the last
+// page, so only a single page is held in memory.
+//
+//	it := api.Moderators(ctx, broadcasterID, nil)
+//
+//	for it.Next() {
+//		moderator := it.Value()
+//	}
+//
+//	err := it.Err()
+type Iterator[T any] struct {
+	api      *API
+	ctx      context.Context
+	cursor   string
+	endpoint string
+	err      error
+	page     []T
+	paged    bool
+	queries  []url.Values
+	started  bool
+	value    T
+}
+
+// NewIterator returns an Iterator over the results of the given Helix
+// endpoint for each of the queries. If paged is set, the cursor of every
+// page is followed.
+func NewIterator[T any](ctx context.Context, api *API, endpoint string, paged bool, queries ...url.Values) *Iterator[T] {
+	return &Iterator[T]{
+		api:      api,
+		ctx:      ctx,
+		endpoint: endpoint,
+		paged:    paged,
+		queries:  queries,
+	}
+}
+
+// Batches returns a copy of the query for every BatchSize values of the
+// given parameter. A single copy is returned if there are no values.
+func Batches(query url.Values, key string, values []string) []url.Values {
+	batches := make([]url.Values, 0, len(values)/BatchSize+1)
+
+	for i := 0; i == 0 || i < len(values); i += BatchSize {
+		j := i + BatchSize
+
+		if j > len(values) {
+			j = len(values)
+		}
+
+		batch := make(url.Values)
+
+		for k, v := range query {
+			batch[k] = append([]string(nil), v...)
+		}
+
+		for _, v := range values[i:j] {
+			batch.Add(key, v)
+		}
+
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+// Collect reads every remaining result of the Iterator into a slice.
+func Collect[T any](it *Iterator[T]) ([]T, error) {
+	results := make([]T, 0)
+
+	for it.Next() {
+		results = append(results, it.Value())
+	}
+
+	return results, it.Err()
+}
+
+// Err returns the error which stopped the Iterator, if any.
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Next advances to the next result, requesting the next page when the
+// current one is exhausted. It returns false once every page was read or
+// a request failed.
+func (it *Iterator[T]) Next() bool {
+	for len(it.page) == 0 {
+		if it.err != nil || !it.fetch() {
+			return false
+		}
+	}
+
+	it.value = it.page[0]
+	it.page = it.page[1:]
+	return true
+}
+
+// Value returns the current result.
+func (it *Iterator[T]) Value() T {
+	return it.value
+}
+
+// fetch requests the next page. It returns false once there are no more
+// pages or the request failed.
+func (it *Iterator[T]) fetch() bool {
+	// Move on to the next query once the last page of the current one was read.
+	if it.started && len(it.cursor) == 0 {
+		it.queries = it.queries[1:]
+		it.started = false
+	}
+
+	if len(it.queries) == 0 {
+		return false
+	}
+
+	query := it.queries[0]
+
+	if it.paged {
+		query.Set("first", strconv.Itoa(PageSize))
+		query.Del("after")
+
+		if len(it.cursor) > 0 {
+			query.Set("after", it.cursor)
+		}
+	}
+
+	body, err := it.api.Get(it.ctx, it.api.HelixURL+it.endpoint+"?"+query.Encode())
+
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	page := new(Page[T])
+
+	if err = json.Unmarshal(body, page); err != nil {
+		it.err = err
+		return false
+	}
+
+	it.cursor = page.Pagination.Cursor
+	it.page = page.Data
+	it.started = true
+
+	if !it.paged {
+		it.cursor = ""
+	}
+
+	return true
+}
@@ -0,0 +1,109 @@
+package twitch
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// MaxRetries is the number of times a request is sent again after the
+	// Twitch API responded with 429 or a server error.
+	MaxRetries = 3
+	// RetryMinDelay is the delay before the first retry.
+	RetryMinDelay = 500 * time.Millisecond
+	// RetryMaxDelay is the longest delay between retries.
+	RetryMaxDelay = 30 * time.Second
+)
+
+// Backoff returns the delay before the given retry attempt. The delay
+// doubles with every attempt up to RetryMaxDelay and half of it is
+// randomized to avoid retrying in lockstep with other requests.
+func Backoff(attempt int) time.Duration {
+	delay := RetryMaxDelay
+
+	if attempt < 32 {
+		if d := RetryMinDelay << uint(attempt); d > 0 && d < RetryMaxDelay {
+			delay = d
+		}
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// RateLimit follows the Ratelimit-Remaining and Ratelimit-Reset headers of
+// the Helix API. Requests take a point from the bucket before being sent
+// and wait for the bucket to reset once it is empty.
+type RateLimit struct {
+	mutex     sync.Mutex
+	known     bool
+	remaining int
+	reset     time.Time
+}
+
+// Update stores the bucket reported by the headers of a response.
+func (r *RateLimit) Update(header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("Ratelimit-Remaining"))
+
+	if err != nil {
+		return
+	}
+
+	reset, err := strconv.ParseInt(header.Get("Ratelimit-Reset"), 10, 64)
+
+	if err != nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.known = true
+	r.remaining = remaining
+	r.reset = time.Unix(reset, 0)
+}
+
+// Reset returns when the bucket is refilled or the zero time if unknown.
+func (r *RateLimit) Reset() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.reset
+}
+
+// Wait takes a point from the bucket, blocking until the bucket resets if
+// it is empty or the context is done.
+func (r *RateLimit) Wait(ctx context.Context) error {
+	for {
+		r.mutex.Lock()
+
+		// The bucket is assumed to be full once its reset has passed.
+		if !r.known || r.remaining > 0 || !time.Now().Before(r.reset) {
+			r.remaining--
+			r.mutex.Unlock()
+			return nil
+		}
+
+		delay := time.Until(r.reset)
+		r.mutex.Unlock()
+
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep blocks for the given duration or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -1,49 +1,130 @@
 package twitch
 
-// AuthorizationResponse is the JSON structure returned by the Twitch API.
-// It contains variables related to token authorization.
-type AuthorizationResponse struct {
-	CreatedAt string   `json:"created_at"`
-	Scopes    []string `json:"scopes"`
-	UpdatedAt string   `json:"updated_at"`
+// BannedUserResponse is the JSON structure returned by the Twitch API.
+// It contains variables related to a user banned from a channel.
+type BannedUserResponse struct {
+	CreatedAt      string `json:"created_at"`
+	ExpiresAt      string `json:"expires_at"`
+	ModeratorID    string `json:"moderator_id"`
+	ModeratorLogin string `json:"moderator_login"`
+	ModeratorName  string `json:"moderator_name"`
+	Reason         string `json:"reason"`
+	UserID         string `json:"user_id"`
+	UserLogin      string `json:"user_login"`
+	UserName       string `json:"user_name"`
+}
+
+// BanResponse is the JSON structure returned by the Twitch API.
+// It contains variables related to a banned or timed out user.
+type BanResponse struct {
+	BroadcasterID string `json:"broadcaster_id"`
+	CreatedAt     string `json:"created_at"`
+	EndTime       string `json:"end_time"`
+	ModeratorID   string `json:"moderator_id"`
+	UserID        string `json:"user_id"`
+}
+
+// BansResponse is the JSON structure returned by the Twitch API.
+// It contains variables related to users banned.
+type BansResponse struct {
+	Data []BanResponse `json:"data"`
+}
+
+// ChatterResponse is the JSON structure returned by the Twitch API.
+// It contains variables related to a user in a chat.
+type ChatterResponse struct {
+	UserID    string `json:"user_id"`
+	UserLogin string `json:"user_login"`
+	UserName  string `json:"user_name"`
+}
+
+// ErrorResponse is the JSON structure returned by the Twitch API.
+// It contains variables related to a failed request.
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	Status  int    `json:"status"`
+}
+
+// EventSubSubscriptionResponse is the JSON structure returned by the Twitch
+// API. It contains variables related to an EventSub subscription.
+type EventSubSubscriptionResponse struct {
+	Condition map[string]string `json:"condition"`
+	Cost      int               `json:"cost"`
+	CreatedAt string            `json:"created_at"`
+	ID        string            `json:"id"`
+	Status    string            `json:"status"`
+	Transport EventSubTransport `json:"transport"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+}
+
+// EventSubSubscriptionsResponse is the JSON structure returned by the
+// Twitch API. It contains variables related to EventSub subscriptions.
+type EventSubSubscriptionsResponse struct {
+	Data         []EventSubSubscriptionResponse `json:"data"`
+	MaxTotalCost int                            `json:"max_total_cost"`
+	Total        int                            `json:"total"`
+	TotalCost    int                            `json:"total_cost"`
+}
+
+// FollowerResponse is the JSON structure returned by the Twitch API.
+// It contains variables related to a follower of a channel.
+type FollowerResponse struct {
+	FollowedAt string `json:"followed_at"`
+	UserID     string `json:"user_id"`
+	UserLogin  string `json:"user_login"`
+	UserName   string `json:"user_name"`
 }
 
-// ChattersResponse is the JSON structure returned by the Twitch API.
-// It contains data related to current chatters in the chat.
-type ChattersResponse struct {
-	ChatterCount int           `json:"chatter_count"`
-	Chatters     Chatters      `json:"chatters"`
-	Links        LinksResponse `json:"_links"`
+// ModeratorResponse is the JSON structure returned by the Twitch API.
+// It contains variables related to a moderator of a channel.
+type ModeratorResponse struct {
+	UserID    string `json:"user_id"`
+	UserLogin string `json:"user_login"`
+	UserName  string `json:"user_name"`
 }
 
-// LinksResponse is the JSON structure returned by the Twitch API.
-// It contains variables related to various links.
-type LinksResponse struct {
-	Channel  string `json:"channel"`
-	Channels string `json:"channels"`
-	Chat     string `json:"chat"`
-	Ingests  string `json:"ingests"`
-	Streams  string `json:"streams"`
-	Teams    string `json:"teams"`
-	User     string `json:"user"`
-	Users    string `json:"users"`
+// ModeratorsResponse is the JSON structure returned by the Twitch API.
+// It contains variables related to moderators retrieved.
+type ModeratorsResponse struct {
+	Data       []ModeratorResponse `json:"data"`
+	Pagination PaginationResponse  `json:"pagination"`
 }
 
-// TokenInfoResponse is the JSON structure returned by the Twitch API.
-// It contains variables related to token bearer.
-type TokenInfoResponse struct {
-	Authorization AuthorizationResponse `json:"authorization"`
-	ClientID      string                `json:"client_id"`
-	Username      string                `json:"user_name"`
-	Valid         bool                  `json:"valid"`
+// PaginationResponse is the JSON structure returned by the Twitch API.
+// It contains the cursor to the next page of results.
+type PaginationResponse struct {
+	Cursor string `json:"cursor"`
 }
 
-// TokenResponse is the JSON structure returned by the Twitch API.
-// It contains variables related to the OAuth 2 token.
-type TokenResponse struct {
-	Identified bool              `json:"identified"`
-	Links      LinksResponse     `json:"_links"`
-	Token      TokenInfoResponse `json:"token"`
+// StreamResponse is the JSON structure returned by the Twitch API.
+// It contains variables related to a live stream.
+type StreamResponse struct {
+	GameID      string `json:"game_id"`
+	GameName    string `json:"game_name"`
+	ID          string `json:"id"`
+	StartedAt   string `json:"started_at"`
+	Title       string `json:"title"`
+	Type        string `json:"type"`
+	UserID      string `json:"user_id"`
+	UserLogin   string `json:"user_login"`
+	UserName    string `json:"user_name"`
+	ViewerCount int    `json:"viewer_count"`
+}
+
+// SubscriptionResponse is the JSON structure returned by the Twitch API.
+// It contains variables related to a subscription to a channel.
+type SubscriptionResponse struct {
+	BroadcasterID string `json:"broadcaster_id"`
+	GifterID      string `json:"gifter_id"`
+	GifterLogin   string `json:"gifter_login"`
+	IsGift        bool   `json:"is_gift"`
+	PlanName      string `json:"plan_name"`
+	Tier          string `json:"tier"`
+	UserID        string `json:"user_id"`
+	UserLogin     string `json:"user_login"`
+	UserName      string `json:"user_name"`
 }
 
 // UserResponse is the JSON structure returned by the Twitch API.
@@ -65,3 +146,13 @@ type UserResponse struct {
 type UsersResponse struct {
 	Data []UserResponse `json:"data"`
 }
+
+// ValidateResponse is the JSON structure returned by the Twitch API.
+// It contains variables related to the validated access token.
+type ValidateResponse struct {
+	ClientID  string   `json:"client_id"`
+	ExpiresIn int      `json:"expires_in"`
+	Login     string   `json:"login"`
+	Scopes    []string `json:"scopes"`
+	UserID    string   `json:"user_id"`
+}
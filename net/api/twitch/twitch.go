@@ -1,158 +1,491 @@
 package twitch
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
 )
 
 const (
+	// EventSubAPI is the websocket URL for EventSub
+	EventSubAPI = "wss://eventsub.wss.twitch.tv/ws"
 	// HelixAPI is the root URL for the Helix API
 	HelixAPI = "https://api.twitch.tv/helix"
-	// KrakenAPI is the root URL for the Kraken API
-	KrakenAPI = "https://api.twitch.tv/kraken"
-	// GetUsers is the endpoint for retrieving user information
-	GetUsers = "https://api.twitch.tv/helix/users"
+	// IDAPI is the root URL for authentication
+	IDAPI = "https://id.twitch.tv"
+
+	// GetUsers is the Helix endpoint for retrieving user information
+	GetUsers = "/users"
+	// OAuthToken is the ID endpoint for exchanging and refreshing tokens
+	OAuthToken = "/oauth2/token"
+	// Validate is the ID endpoint for validating access tokens
+	Validate = "/oauth2/validate"
+)
+
+var (
+	// ErrInvalidToken is returned when Twitch rejects the access token.
+	ErrInvalidToken = errors.New("Invalid token")
+	// RefreshMargin is how long before its expiry an access token is refreshed.
+	RefreshMargin = 5 * time.Minute
+	// Timeout is the time limit of a single request including reading the body.
+	Timeout = 30 * time.Second
+	// ValidationInterval is the longest a validated token is trusted before
+	// it is validated again. Twitch requires validation at least hourly.
+	ValidationInterval = time.Hour
 )
 
+// APIError is returned for responses of the Twitch API without a
+// successful status code.
+type APIError struct {
+	Message    string
+	Status     string
+	StatusCode int
+}
+
+// NewAPIError creates an APIError from the response and its body.
+func NewAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{Status: resp.Status, StatusCode: resp.StatusCode}
+	response := new(ErrorResponse)
+
+	if err := json.Unmarshal(body, response); err == nil {
+		apiErr.Message = response.Message
+	}
+
+	return apiErr
+}
+
+// Error returns the message of the Twitch API or the status if none was given.
+func (e *APIError) Error() string {
+	if len(e.Message) == 0 {
+		return e.Status
+	}
+
+	return e.Status + " - " + e.Message
+}
+
+// Temporary returns whether the request may succeed if sent again.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// ExpiryHandler is notified once the access token of an API is no longer
+// valid and cannot be refreshed.
+type ExpiryHandler interface {
+	Expired()
+}
+
+// RefreshHandler is implemented by handlers which need to store the token
+// after the API has refreshed it.
+type RefreshHandler interface {
+	Refreshed(token *oauth2.Token)
+}
+
+// Option configures an API created by NewAPI.
+type Option func(*API)
+
+// WithClient sets the http.Client used for requests.
+func WithClient(client *http.Client) Option {
+	return func(a *API) {
+		a.Client = client
+	}
+}
+
+// WithEventSubURL sets the websocket URL of EventSub.
+func WithEventSubURL(url string) Option {
+	return func(a *API) {
+		a.EventSubURL = url
+	}
+}
+
+// WithHelixURL sets the root URL of the Helix API.
+func WithHelixURL(url string) Option {
+	return func(a *API) {
+		a.HelixURL = url
+	}
+}
+
+// WithIDURL sets the root URL for validating and refreshing tokens.
+func WithIDURL(url string) Option {
+	return func(a *API) {
+		a.IDURL = url
+	}
+}
+
 // API is a structure used to communicate with the Twitch API. Stores the
-// access token as well as a http.Client.
+// access token as well as a http.Client. ClientID is sent along with
+// every request as required by the Helix API. The token is validated
+// before requests and the result is cached until ValidationInterval
+// passes or the token expires. With an OAuth configuration and a refresh
+// token, the token is refreshed shortly before its expiry or once it is
+// rejected. The root URLs default to those of Twitch.
 type API struct {
-	Client *http.Client
-	Token  string
+	Client      *http.Client
+	ClientID    string
+	EventSubURL string
+	Handler     ExpiryHandler
+	HelixURL    string
+	IDURL       string
+	OAuth       *oauth2.Config
+	RateLimit   *RateLimit
+	Token       string
+
+	expired      bool
+	expiry       time.Time
+	mutex        sync.RWMutex
+	refreshing   sync.Mutex
+	refreshToken string
+	validatedAt  time.Time
+	validation   *ValidateResponse
 }
 
 // NewAPI creates and initilaizes an API. NewAPI accepts an access token
-// as its parameter.
-func NewAPI(token string) *API {
-	return &API{
-		Client: new(http.Client),
-		Token:  token,
+// and options overriding the defaults as its parameters.
+func NewAPI(token string, options ...Option) *API {
+	api := &API{
+		Client:      &http.Client{Timeout: Timeout},
+		EventSubURL: EventSubAPI,
+		HelixURL:    HelixAPI,
+		IDURL:       IDAPI,
+		RateLimit:   new(RateLimit),
+		Token:       token,
+	}
+
+	for _, option := range options {
+		option(api)
 	}
+
+	return api
 }
 
 // AuthType returns appropriate authorization type based on given URL.
-func AuthType(url string) string {
-	if strings.Contains(url, "helix") {
+func (a *API) AuthType(url string) string {
+	if strings.HasPrefix(url, a.HelixURL) {
 		return "Bearer"
 	}
 
 	return "OAuth"
 }
 
-// Get sends a GET request to the specified URL returning the body
-// as bytes or an error.
-func (a *API) Get(url string) ([]byte, error) {
-	if response, err := a.ValidToken(); err != nil || !response.Token.Valid {
-		if !response.Token.Valid {
-			return nil, errors.New("Invalid token")
-		}
+// Do sends a request with the given method to the specified URL returning
+// the body as bytes or an error. A non-nil payload is encoded as the JSON
+// body of the request. Responses without a successful status code are
+// returned as an *APIError. The token is refreshed and the request sent
+// again if it was rejected.
+func (a *API) Do(ctx context.Context, method, url string, payload interface{}) ([]byte, error) {
+	a.mutex.RLock()
+	token, expiry := a.Token, a.expiry
+	a.mutex.RUnlock()
+
+	// A failed refresh is ignored as the token is still valid for a while.
+	if !expiry.IsZero() && time.Until(expiry) < RefreshMargin {
+		a.refresh(ctx, token)
+	}
 
+	if _, err := a.Validate(ctx); err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	a.mutex.RLock()
+	token = a.Token
+	a.mutex.RUnlock()
+	body, err := a.send(ctx, method, url, payload, token)
+
+	if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusUnauthorized && a.refresh(ctx, token) == nil {
+		a.mutex.RLock()
+		token = a.Token
+		a.mutex.RUnlock()
+		body, err = a.send(ctx, method, url, payload, token)
+	}
+
+	return body, err
+}
+
+// send sends a request following the rate limit of the Helix API. The
+// request is retried with Backoff on 429 and server errors.
+func (a *API) send(ctx context.Context, method, url string, payload interface{}, token string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		if err := a.RateLimit.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		body, header, err := a.do(ctx, method, url, payload, token)
+		a.RateLimit.Update(header)
+		apiErr, ok := err.(*APIError)
+
+		if !ok || !apiErr.Temporary() || attempt >= MaxRetries {
+			return body, err
+		}
+
+		delay := Backoff(attempt)
+
+		// Too many requests are retried once the bucket is refilled.
+		if reset := a.RateLimit.Reset(); apiErr.StatusCode == http.StatusTooManyRequests && time.Until(reset) > 0 {
+			delay = time.Until(reset)
+		}
+
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// do sends a single request with the given token returning the body and
+// headers of the response.
+func (a *API) do(ctx context.Context, method, url string, payload interface{}, token string) ([]byte, http.Header, error) {
+	var content io.Reader
+
+	if payload != nil {
+		data, err := json.Marshal(payload)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		content = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, content)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	req.Header.Add("Authorization", a.AuthType(url)+" "+token)
+
+	if len(a.ClientID) > 0 {
+		req.Header.Add("Client-Id", a.ClientID)
+	}
+
+	if payload != nil {
+		req.Header.Add("Content-Type", "application/json")
 	}
 
-	req.Header.Add("Authorization", AuthType(url)+" "+a.Token)
 	resp, err := a.Client.Do(req)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 
 	if err != nil {
-		return nil, err
+		return nil, resp.Header, err
 	}
 
 	if err = resp.Body.Close(); err != nil {
-		return nil, err
+		return nil, resp.Header, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, resp.Header, NewAPIError(resp, body)
 	}
 
-	return body, nil
+	return body, resp.Header, nil
+}
+
+// Get sends a GET request to the specified URL returning the body
+// as bytes or an error.
+func (a *API) Get(ctx context.Context, url string) ([]byte, error) {
+	return a.Do(ctx, http.MethodGet, url, nil)
 }
 
-// GetChatters returns a ChattersResponse for the given channel.
-func (a *API) GetChatters(channel string) (*ChattersResponse, error) {
-	body, err := a.Get("http://tmi.twitch.tv/group/user/" + channel + "/chatters")
+// GetUsers returns a UserResponse with the supplied arguments.
+// If no arguments are given, then the user to which the access
+// token belongs to is returned. IDs and logins are requested in
+// batches of BatchSize.
+func (a *API) GetUsers(ctx context.Context, id, login []string) (*UsersResponse, error) {
+	users, err := Collect(a.Users(ctx, id, login))
 
 	if err != nil {
 		return nil, err
 	}
 
-	resp := new(ChattersResponse)
+	return &UsersResponse{Data: users}, nil
+}
+
+// Users returns an Iterator over the users with the supplied arguments.
+// If no arguments are given, then the user to which the access token
+// belongs to is returned.
+func (a *API) Users(ctx context.Context, id, login []string) *Iterator[UserResponse] {
+	queries := make([]url.Values, 0)
 
-	if err = json.Unmarshal(body, resp); err != nil {
-		return nil, err
+	if len(id) > 0 || len(login) == 0 {
+		queries = append(queries, Batches(make(url.Values), "id", id)...)
 	}
 
-	return resp, nil
-}
+	if len(login) > 0 {
+		queries = append(queries, Batches(make(url.Values), "login", login)...)
+	}
 
-// GetUsers returns a UserResponse with the supplied arguments.
-// If no arguments are given, then the user to which the access
-// token belongs to is returned.
-func (a *API) GetUsers(id, login []string) (*UsersResponse, error) {
-	query := make(url.Values)
+	return NewIterator[UserResponse](ctx, a, GetUsers, false, queries...)
+}
 
-	for _, v := range id {
-		query.Add("id", v)
+// refresh exchanges the refresh token for a new access token unless the
+// stale token was already replaced, such as by a concurrent refresh.
+func (a *API) refresh(ctx context.Context, stale string) error {
+	a.refreshing.Lock()
+	defer a.refreshing.Unlock()
+	a.mutex.RLock()
+	token, refreshToken := a.Token, a.refreshToken
+	a.mutex.RUnlock()
+
+	if strings.Compare(token, stale) != 0 {
+		return nil
 	}
 
-	for _, v := range login {
-		query.Add("login", v)
+	if a.OAuth == nil || len(refreshToken) == 0 {
+		return ErrInvalidToken
 	}
 
-	body, err := a.Get(GetUsers + "?" + query.Encode())
+	// Tokens are refreshed at the configured root URL.
+	config := *a.OAuth
+	config.Endpoint.TokenURL = a.IDURL + OAuthToken
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, a.Client)
+	source := config.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken})
+	fresh, err := source.Token()
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	resp := new(UsersResponse)
+	a.SetToken(fresh)
 
-	if err = json.Unmarshal(body, resp); err != nil {
-		return nil, err
+	if refresher, ok := a.Handler.(RefreshHandler); ok {
+		refresher.Refreshed(fresh)
 	}
 
-	return resp, nil
+	return nil
 }
 
-// ValidToken sends a request to the root URL to check if access
-// token is still valid. Tokens must be validated before each request.
-func (a *API) ValidToken() (*TokenResponse, error) {
-	req, err := http.NewRequest(http.MethodGet, KrakenAPI, nil)
+// Scopes returns the scopes granted to the token when it was last validated.
+func (a *API) Scopes() []string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if a.validation == nil {
+		return nil
+	}
+
+	return a.validation.Scopes
+}
+
+// SetToken replaces the access and refresh token and discards the cached
+// validation.
+func (a *API) SetToken(token *oauth2.Token) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.expired = false
+	a.expiry = token.Expiry
+	a.refreshToken = token.RefreshToken
+	a.Token = token.AccessToken
+	a.validatedAt = time.Time{}
+	a.validation = nil
+}
+
+// UserID returns the ID of the user the token belonged to when it was last
+// validated.
+func (a *API) UserID() string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if a.validation == nil {
+		return ""
+	}
+
+	return a.validation.UserID
+}
+
+// Validate returns the validation of the access token. A cached validation
+// is returned unless ValidationInterval has passed or the token expired.
+// A rejected token is refreshed if possible, otherwise the Handler is
+// notified once.
+func (a *API) Validate(ctx context.Context) (*ValidateResponse, error) {
+	return a.validate(ctx, true)
+}
+
+// validate validates the access token and refreshes a rejected token if
+// retry is set.
+func (a *API) validate(ctx context.Context, retry bool) (*ValidateResponse, error) {
+	a.mutex.RLock()
+	token, validation, validatedAt := a.Token, a.validation, a.validatedAt
+	a.mutex.RUnlock()
+
+	if validation != nil {
+		elapsed := time.Since(validatedAt)
+		expiry := time.Duration(validation.ExpiresIn) * time.Second
+
+		if elapsed < ValidationInterval && (expiry == 0 || elapsed < expiry) {
+			return validation, nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.IDURL+Validate, nil)
 
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("Authorization", AuthType(KrakenAPI)+" "+a.Token)
+	req.Header.Add("Authorization", "OAuth "+token)
 	resp, err := a.Client.Do(req)
 
 	if err != nil {
 		return nil, err
 	}
 
-	decoder := json.NewDecoder(resp.Body)
-	response := new(TokenResponse)
-	err = decoder.Decode(response)
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if closeErr := resp.Body.Close(); err == nil {
+		err = closeErr
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && retry && a.refresh(ctx, token) == nil {
+		return a.validate(ctx, false)
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	// The token was replaced while validating.
+	if strings.Compare(token, a.Token) != 0 {
+		return nil, ErrInvalidToken
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		a.validation = nil
+
+		if !a.expired && a.Handler != nil {
+			go a.Handler.Expired()
+		}
+
+		a.expired = true
+		return nil, ErrInvalidToken
+	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	if err = resp.Body.Close(); err != nil {
+	if resp.StatusCode != http.StatusOK {
+		return nil, NewAPIError(resp, body)
+	}
+
+	response := new(ValidateResponse)
+
+	if err := json.Unmarshal(body, response); err != nil {
 		return nil, err
 	}
 
+	a.validatedAt = time.Now()
+	a.validation = response
 	return response, nil
 }
@@ -0,0 +1,436 @@
+package twitch_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kookehs/kneissbot/net/api/twitch"
+	"github.com/kookehs/kneissbot/net/api/twitch/twitchtest"
+	"golang.org/x/oauth2"
+)
+
+// handler records the notifications of an API about its token.
+type handler struct {
+	expired int
+	mutex   sync.Mutex
+	tokens  []string
+}
+
+func (h *handler) Expired() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.expired++
+}
+
+func (h *handler) Refreshed(token *oauth2.Token) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.tokens = append(h.tokens, token.AccessToken)
+}
+
+func TestValidate(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.AddToken("token", "", "kneissbot", time.Hour, "chat:read", "chat:edit")
+	api := twitch.NewAPI("token", server.Options()...)
+	resp, err := api.Validate(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Login != "kneissbot" || resp.ExpiresIn <= 0 || !reflect.DeepEqual(resp.Scopes, []string{"chat:read", "chat:edit"}) {
+		t.Errorf("Validate returned %+v", resp)
+	}
+
+	if got, want := api.UserID(), server.AddUser("kneissbot"); got != want {
+		t.Errorf("UserID() = %q, want %q", got, want)
+	}
+
+	// The validation is cached, so a revoked token is only noticed once it expires.
+	server.Revoke("token")
+
+	if _, err := api.Validate(context.Background()); err != nil {
+		t.Errorf("Cached validation returned %v", err)
+	}
+}
+
+func TestValidateRejected(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	h := new(handler)
+	api := twitch.NewAPI("unknown", server.Options()...)
+	api.Handler = h
+
+	for i := 0; i < 2; i++ {
+		if _, err := api.Validate(context.Background()); err != twitch.ErrInvalidToken {
+			t.Fatalf("Validate returned %v, want %v", err, twitch.ErrInvalidToken)
+		}
+	}
+
+	// The handler is notified once in its own goroutine.
+	time.Sleep(10 * time.Millisecond)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.expired != 1 {
+		t.Errorf("Handler notified %v times, want 1", h.expired)
+	}
+}
+
+func TestValidateRefresh(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.AddToken("stale", "refresh", "kneissbot", time.Nanosecond)
+	h := new(handler)
+	api := twitch.NewAPI("", server.Options()...)
+	api.Handler = h
+	api.OAuth = &oauth2.Config{ClientID: "client"}
+	api.SetToken(&oauth2.Token{AccessToken: "stale", RefreshToken: "refresh"})
+	resp, err := api.Validate(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Login != "kneissbot" || api.Token != "access-1" {
+		t.Errorf("Validated %q with token %q", resp.Login, api.Token)
+	}
+
+	if !reflect.DeepEqual(h.tokens, []string{"access-1"}) {
+		t.Errorf("Handler received tokens %v", h.tokens)
+	}
+
+	// Helix requests rejected with the refreshed token refresh it again.
+	server.Revoke("access-1")
+	api.SetToken(&oauth2.Token{AccessToken: "access-1", RefreshToken: "refresh-1"})
+
+	if _, err := api.GetUsers(context.Background(), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if api.Token != "access-2" {
+		t.Errorf("Token %q after rejection, want access-2", api.Token)
+	}
+}
+
+func TestValidateError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"status":500,"message":"Validation unavailable"}`))
+	}))
+	defer server.Close()
+	api := twitch.NewAPI("token", twitch.WithIDURL(server.URL))
+	_, err := api.Validate(context.Background())
+	apiErr, ok := err.(*twitch.APIError)
+
+	if !ok || apiErr.StatusCode != http.StatusInternalServerError || apiErr.Message != "Validation unavailable" {
+		t.Errorf("Validate returned %#v", err)
+	}
+}
+
+// retryQuickly shortens the delay between retries until the test ends.
+func retryQuickly(t *testing.T) {
+	delay := twitch.RetryMinDelay
+	twitch.RetryMinDelay = time.Millisecond
+	t.Cleanup(func() { twitch.RetryMinDelay = delay })
+}
+
+func TestRetry(t *testing.T) {
+	retryQuickly(t)
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.AddToken("token", "", "kneissbot", 0)
+	id := server.AddUser("kneissbot")
+	api := twitch.NewAPI("token", server.Options()...)
+
+	// Server errors are sent again.
+	server.Fail(http.StatusServiceUnavailable, twitch.MaxRetries)
+	users, err := api.GetUsers(context.Background(), nil, []string{"kneissbot"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users.Data) != 1 || users.Data[0].ID != id {
+		t.Errorf("GetUsers returned %+v", users.Data)
+	}
+
+	// Requests give up once MaxRetries is exceeded.
+	server.Fail(http.StatusBadGateway, twitch.MaxRetries+1)
+	_, err = api.GetUsers(context.Background(), nil, []string{"kneissbot"})
+	apiErr, ok := err.(*twitch.APIError)
+
+	if !ok || apiErr.StatusCode != http.StatusBadGateway || apiErr.Message != http.StatusText(http.StatusBadGateway) {
+		t.Errorf("GetUsers returned %#v", err)
+	}
+
+	// Client errors are not sent again.
+	server.Fail(http.StatusBadRequest, 1)
+
+	if _, err := api.GetUsers(context.Background(), nil, []string{"kneissbot"}); err == nil {
+		t.Error("Bad request sent again")
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.AddToken("token", "", "kneissbot", 0)
+	api := twitch.NewAPI("token", server.Options()...)
+	server.Fail(http.StatusServiceUnavailable, twitch.MaxRetries)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Waiting for the next retry ends with the context.
+	if _, err := api.GetUsers(ctx, nil, []string{"kneissbot"}); err != context.DeadlineExceeded {
+		t.Errorf("GetUsers returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRateLimitEmpty(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.AddToken("token", "", "kneissbot", 0)
+	server.SetRateLimit(1)
+	api := twitch.NewAPI("token", server.Options()...)
+
+	if _, err := api.GetUsers(context.Background(), nil, []string{"kneissbot"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The empty bucket is not refilled until its reset a minute later.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := api.GetUsers(ctx, nil, []string{"kneissbot"}); err != context.DeadlineExceeded {
+		t.Errorf("GetUsers returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRateLimitReset(t *testing.T) {
+	retryQuickly(t)
+	var mutex sync.Mutex
+	var reset time.Time
+	var retried time.Time
+
+	// The first request is rejected with an empty bucket resetting within a second.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if r.URL.Path == twitch.Validate {
+			w.Write([]byte(`{"login":"kneissbot","user_id":"1"}`))
+			return
+		}
+
+		if reset.IsZero() {
+			reset = time.Unix(time.Now().Add(time.Second).Unix(), 0)
+			w.Header().Set("Ratelimit-Remaining", "0")
+			w.Header().Set("Ratelimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		retried = time.Now()
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer server.Close()
+	api := twitch.NewAPI("token", twitch.WithHelixURL(server.URL), twitch.WithIDURL(server.URL))
+
+	if _, err := api.GetUsers(context.Background(), nil, []string{"kneissbot"}); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if retried.Before(reset) {
+		t.Errorf("Retried at %v before the reset at %v", retried, reset)
+	}
+}
+
+// logins returns n distinct logins.
+func logins(n int) []string {
+	logins := make([]string, n)
+
+	for i := range logins {
+		logins[i] = fmt.Sprintf("user%03d", i)
+	}
+
+	return logins
+}
+
+func TestBatches(t *testing.T) {
+	query := url.Values{"broadcaster_id": {"1"}}
+	batches := twitch.Batches(query, "user_id", logins(250))
+
+	if len(batches) != 3 {
+		t.Fatalf("Batches returned %v batches, want 3", len(batches))
+	}
+
+	for i, want := range []int{100, 100, 50} {
+		if got := len(batches[i]["user_id"]); got != want || batches[i].Get("broadcaster_id") != "1" {
+			t.Errorf("Batch %v has %v values and query %v", i, got, batches[i])
+		}
+	}
+
+	if len(query["user_id"]) > 0 {
+		t.Error("Batches changed the query")
+	}
+
+	// A query without values is sent once.
+	if batches := twitch.Batches(query, "user_id", nil); len(batches) != 1 {
+		t.Errorf("Batches without values returned %v batches", len(batches))
+	}
+}
+
+func TestPagination(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.AddToken("token", "", "kneissbot", 0)
+	broadcaster := server.AddUser("kneissbot")
+	moderators := logins(250)
+	ids := make([]string, 0, len(moderators))
+
+	for _, login := range moderators {
+		ids = append(ids, server.AddUser(login))
+	}
+
+	server.SetModerators("kneissbot", moderators...)
+	api := twitch.NewAPI("token", server.Options()...)
+
+	// The cursor is followed across every page.
+	got, err := api.GetModerators(context.Background(), broadcaster, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(moderators) {
+		t.Fatalf("GetModerators returned %v moderators, want %v", len(got), len(moderators))
+	}
+
+	for i, moderator := range got {
+		if moderator.UserLogin != moderators[i] {
+			t.Fatalf("Moderator %v is %v, want %v", i, moderator.UserLogin, moderators[i])
+		}
+	}
+
+	// More than BatchSize IDs or logins are split into several requests.
+	filtered, err := api.GetModerators(context.Background(), broadcaster, ids)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(filtered) != len(ids) {
+		t.Errorf("GetModerators of %v IDs returned %v moderators", len(ids), len(filtered))
+	}
+
+	users, err := api.GetUsers(context.Background(), nil, moderators)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users.Data) != len(moderators) {
+		t.Errorf("GetUsers of %v logins returned %v users", len(moderators), len(users.Data))
+	}
+}
+
+func TestModeration(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	broadcaster := server.AddUser("kneissbot")
+	alice := server.AddUser("alice")
+	bob := server.AddUser("bob")
+	server.AddToken("broadcaster", "", "kneissbot", 0)
+	server.AddToken("moderator", "", "alice", 0)
+	api := twitch.NewAPI("broadcaster", server.Options()...)
+
+	if err := api.AddModerator(ctx, broadcaster, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := api.AddVIP(ctx, broadcaster, bob); err != nil {
+		t.Fatal(err)
+	}
+
+	moderators, err := api.GetModerators(ctx, broadcaster, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(moderators) != 1 || moderators[0].UserLogin != "alice" || moderators[0].UserID != alice {
+		t.Errorf("GetModerators returned %+v", moderators)
+	}
+
+	if got := server.VIPs("kneissbot"); !reflect.DeepEqual(got, []string{"bob"}) {
+		t.Errorf("Server has VIPs %v", got)
+	}
+
+	// Moderators ban on behalf of themselves with their own token.
+	moderator := twitch.NewAPI("moderator", server.Options()...)
+	resp, err := moderator.BanUser(ctx, broadcaster, alice, twitch.BanRequest{Duration: 60, Reason: "spam", UserID: bob})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Data) != 1 || resp.Data[0].UserID != bob || len(resp.Data[0].EndTime) == 0 {
+		t.Errorf("BanUser returned %+v", resp.Data)
+	}
+
+	banned, err := twitch.Collect(api.BannedUsers(ctx, broadcaster, nil))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(banned) != 1 || banned[0].UserLogin != "bob" {
+		t.Errorf("BannedUsers returned %+v", banned)
+	}
+
+	if err := moderator.DeleteChatMessages(ctx, broadcaster, alice, "message"); err != nil {
+		t.Error(err)
+	}
+
+	if err := moderator.UnbanUser(ctx, broadcaster, alice, bob); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := api.RemoveModerator(ctx, broadcaster, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := api.RemoveVIP(ctx, broadcaster, bob); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := server.Moderators("kneissbot"); len(got) > 0 {
+		t.Errorf("Server kept moderators %v", got)
+	}
+
+	if got := server.Bans("kneissbot"); len(got) > 0 {
+		t.Errorf("Server kept bans %v", got)
+	}
+
+	// Only moderators may ban.
+	_, err = moderator.BanUser(ctx, broadcaster, alice, twitch.BanRequest{UserID: bob})
+	apiErr, ok := err.(*twitch.APIError)
+
+	if !ok || apiErr.StatusCode != http.StatusForbidden || apiErr.Temporary() {
+		t.Errorf("BanUser by a former moderator returned %v", err)
+	}
+}
@@ -0,0 +1,387 @@
+package twitchtest
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kookehs/kneissbot/net/api/twitch"
+	"golang.org/x/net/websocket"
+)
+
+// KeepaliveTimeout is the default keepalive timeout of EventSub sessions.
+const KeepaliveTimeout = 10 * time.Second
+
+// session is an EventSub websocket session. Messages are queued and
+// written by a goroutine of their own, which also sends keepalives while
+// the session is idle.
+type session struct {
+	conn     *websocket.Conn
+	done     chan struct{}
+	id       string
+	outgoing chan *twitch.EventSubMessage
+}
+
+// DisconnectEventSub drops every EventSub connection. Their sessions end
+// and their subscriptions are deleted.
+func (s *Server) DisconnectEventSub() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, session := range s.sessions {
+		session.conn.Close()
+	}
+}
+
+// Notify sends a notification with the given event to every session
+// subscribed to the subscription type of the given channel. It returns
+// the number of notifications sent.
+func (s *Server) Notify(subscriptionType, channel string, event interface{}) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.notify(subscriptionType, channel, event)
+}
+
+// notify sends a notification like Notify while the Server is locked.
+func (s *Server) notify(subscriptionType, channel string, event interface{}) int {
+	data, err := json.Marshal(event)
+
+	if err != nil {
+		panic(err)
+	}
+
+	id := s.addUser(channel).ID
+	sent := 0
+
+	for _, subscription := range s.subscriptions {
+		if strings.Compare(subscription.Type, subscriptionType) != 0 || !conditionOn(subscription.Condition, id) {
+			continue
+		}
+
+		session, ok := s.sessions[subscription.Transport.SessionID]
+
+		if !ok {
+			continue
+		}
+
+		sub := *subscription
+		message := s.message(twitch.NotificationMessage, &sub, twitch.EventSubPayload{
+			Event:        data,
+			Subscription: &sub,
+		})
+
+		for i := 0; i < s.deliveries || i == 0; i++ {
+			session.outgoing <- message
+		}
+
+		sent++
+	}
+
+	return sent
+}
+
+// MoveEventSub asks every EventSub session to reconnect to a connection
+// welcomed to a new session. The subscriptions of the previous session
+// are deleted once its connection is closed.
+func (s *Server) MoveEventSub() {
+	s.reconnectEventSub(false)
+}
+
+// ReconnectEventSub asks every EventSub session to reconnect to a new
+// connection of the same session.
+func (s *Server) ReconnectEventSub() {
+	s.reconnectEventSub(true)
+}
+
+// reconnectEventSub asks every EventSub session to reconnect, keeping the
+// session if requested.
+func (s *Server) reconnectEventSub(keep bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, session := range s.sessions {
+		url := s.EventSubURL
+
+		if keep {
+			url += "?session=" + id
+		}
+
+		session.outgoing <- s.message(twitch.ReconnectMessage, nil, twitch.EventSubPayload{
+			Session: &twitch.EventSubSession{
+				ID:           id,
+				ReconnectURL: url,
+				Status:       "reconnecting",
+			},
+		})
+	}
+}
+
+// RevokeEventSub revokes every subscription to events of the given channel.
+func (s *Server) RevokeEventSub(channel string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := s.addUser(channel).ID
+
+	for key, subscription := range s.subscriptions {
+		if !conditionOn(subscription.Condition, id) {
+			continue
+		}
+
+		delete(s.subscriptions, key)
+		subscription.Status = "authorization_revoked"
+
+		if session, ok := s.sessions[subscription.Transport.SessionID]; ok {
+			session.outgoing <- s.message(twitch.RevocationMessage, subscription, twitch.EventSubPayload{
+				Subscription: subscription,
+			})
+		}
+	}
+}
+
+// SetDeliveries sets how many times every notification is delivered with
+// the same message ID, as Twitch may deliver a notification more than once.
+func (s *Server) SetDeliveries(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.deliveries = n
+}
+
+// SetKeepalive sets the keepalive timeout of new EventSub sessions in
+// whole seconds. A negative timeout announces its absolute value but
+// never sends keepalives, so that clients consider their connection dead.
+func (s *Server) SetKeepalive(timeout time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.keepalive = timeout
+}
+
+// Subscriptions returns the sorted types of the EventSub subscriptions to
+// events of the given channel.
+func (s *Server) Subscriptions(channel string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := s.addUser(channel).ID
+	types := make([]string, 0)
+
+	for _, subscription := range s.subscriptions {
+		if conditionOn(subscription.Condition, id) {
+			types = append(types, subscription.Type)
+		}
+	}
+
+	sort.Strings(types)
+	return types
+}
+
+// handleEventSub serves an EventSub websocket connection. A connection
+// with the ID of an existing session takes the session over and the
+// previous connection is closed once the new one was welcomed.
+func (s *Server) handleEventSub(conn *websocket.Conn) {
+	id := conn.Request().URL.Query().Get("session")
+	s.mutex.Lock()
+	previous, reconnect := s.sessions[id]
+
+	if !reconnect {
+		s.sessionCount++
+		id = "session-" + strconv.Itoa(s.sessionCount)
+	}
+
+	current := &session{
+		conn:     conn,
+		done:     make(chan struct{}),
+		id:       id,
+		outgoing: make(chan *twitch.EventSubMessage, 64),
+	}
+
+	keepalive := s.keepalive
+	announced := keepalive
+
+	if announced < 0 {
+		announced = -announced
+	} else if announced == 0 {
+		announced = KeepaliveTimeout
+	}
+
+	s.sessions[id] = current
+	current.outgoing <- s.message(twitch.WelcomeMessage, nil, twitch.EventSubPayload{
+		Session: &twitch.EventSubSession{
+			ConnectedAt:             time.Now().UTC().Format(time.RFC3339Nano),
+			ID:                      id,
+			KeepaliveTimeoutSeconds: int(announced / time.Second),
+			Status:                  "connected",
+		},
+	})
+
+	s.mutex.Unlock()
+	go s.write(current, keepalive)
+
+	if reconnect {
+		previous.conn.Close()
+	}
+
+	// Clients never send messages, so reading only waits for the connection to close.
+	io.Copy(ioutil.Discard, conn)
+	close(current.done)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.sessions[id] != current {
+		return
+	}
+
+	delete(s.sessions, id)
+
+	for key, subscription := range s.subscriptions {
+		if strings.Compare(subscription.Transport.SessionID, id) == 0 {
+			delete(s.subscriptions, key)
+		}
+	}
+}
+
+// handleSubscriptions creates or deletes an EventSub subscription.
+func (s *Server) handleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		request := new(twitch.EventSubSubscriptionRequest)
+
+		if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+			writeError(w, http.StatusBadRequest, "Malformed request")
+			return
+		}
+
+		if _, ok := s.sessions[request.Transport.SessionID]; !ok || strings.Compare(request.Transport.Method, twitch.WebsocketMethod) != 0 {
+			writeError(w, http.StatusBadRequest, "Invalid transport")
+			return
+		}
+
+		for _, subscription := range s.subscriptions {
+			if strings.Compare(subscription.Type, request.Type) == 0 &&
+				strings.Compare(subscription.Version, request.Version) == 0 &&
+				strings.Compare(subscription.Transport.SessionID, request.Transport.SessionID) == 0 &&
+				sameCondition(subscription.Condition, request.Condition) {
+				writeError(w, http.StatusConflict, "Subscription already exists")
+				return
+			}
+		}
+
+		s.subscriptionCount++
+		now := time.Now().UTC().Format(time.RFC3339Nano)
+		subscription := &twitch.EventSubSubscriptionResponse{
+			Condition: request.Condition,
+			CreatedAt: now,
+			ID:        "subscription-" + strconv.Itoa(s.subscriptionCount),
+			Status:    "enabled",
+			Transport: twitch.EventSubTransport{
+				ConnectedAt: now,
+				Method:      request.Transport.Method,
+				SessionID:   request.Transport.SessionID,
+			},
+			Type:    request.Type,
+			Version: request.Version,
+		}
+
+		s.subscriptions[subscription.ID] = subscription
+		writeJSON(w, http.StatusAccepted, twitch.EventSubSubscriptionsResponse{
+			Data:  []twitch.EventSubSubscriptionResponse{*subscription},
+			Total: len(s.subscriptions),
+		})
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+
+		if _, ok := s.subscriptions[id]; !ok {
+			writeError(w, http.StatusNotFound, "Subscription not found")
+			return
+		}
+
+		delete(s.subscriptions, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+	}
+}
+
+// message returns an EventSub message of the given type with a new ID.
+func (s *Server) message(messageType string, subscription *twitch.EventSubSubscriptionResponse, payload twitch.EventSubPayload) *twitch.EventSubMessage {
+	s.messageCount++
+	message := &twitch.EventSubMessage{
+		Metadata: twitch.EventSubMetadata{
+			MessageID:        "message-" + strconv.Itoa(s.messageCount),
+			MessageTimestamp: time.Now().UTC().Format(time.RFC3339Nano),
+			MessageType:      messageType,
+		},
+		Payload: payload,
+	}
+
+	if subscription != nil {
+		message.Metadata.SubscriptionType = subscription.Type
+		message.Metadata.SubscriptionVersion = subscription.Version
+	}
+
+	return message
+}
+
+// write sends the queued messages of the session and a keepalive whenever
+// the session was idle for the given timeout, unless it is negative.
+func (s *Server) write(current *session, keepalive time.Duration) {
+	if keepalive == 0 {
+		keepalive = KeepaliveTimeout
+	}
+
+	var idle <-chan time.Time
+
+	for {
+		var timer *time.Timer
+
+		if keepalive > 0 {
+			timer = time.NewTimer(keepalive)
+			idle = timer.C
+		}
+
+		select {
+		case message := <-current.outgoing:
+			websocket.JSON.Send(current.conn, message)
+		case <-idle:
+			s.mutex.Lock()
+			message := s.message(twitch.KeepaliveMessage, nil, twitch.EventSubPayload{})
+			s.mutex.Unlock()
+			websocket.JSON.Send(current.conn, message)
+		case <-current.done:
+			return
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// conditionOn returns whether or not the condition refers to the given user ID.
+func conditionOn(condition map[string]string, id string) bool {
+	for _, value := range condition {
+		if strings.Compare(value, id) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameCondition returns whether or not the conditions are equal.
+func sameCondition(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if w, ok := b[k]; !ok || strings.Compare(v, w) != 0 {
+			return false
+		}
+	}
+
+	return true
+}
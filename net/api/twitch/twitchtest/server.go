@@ -0,0 +1,665 @@
+package twitchtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kookehs/kneissbot/net/api/twitch"
+	"golang.org/x/net/websocket"
+)
+
+// RateLimit is the default number of Helix requests allowed per minute.
+const RateLimit = 800
+
+// Server is an in-process HTTP server which answers like the Twitch API.
+// It keeps users, tokens, chatters, moderators, bans, VIPs and streams in
+// memory. Channels and users are identified by login. Helix requests are
+// rate limited with the Ratelimit headers of Twitch. EventSub sessions
+// are served over a websocket at EventSubURL. Server is intended for
+// tests.
+type Server struct {
+	EventSubURL string
+	URL         string
+
+	bans              map[string]map[string]bool
+	chatters          map[string]map[string]bool
+	deliveries        int
+	failStatus        int
+	failures          int
+	ids               map[string]string
+	issued            int
+	keepalive         time.Duration
+	limit             int
+	messageCount      int
+	moderators        map[string]map[string]bool
+	mutex             sync.Mutex
+	refresh           map[string]string
+	remaining         int
+	reset             time.Time
+	server            *httptest.Server
+	sessionCount      int
+	sessions          map[string]*session
+	streams           map[string]time.Time
+	subscriptionCount int
+	subscriptions     map[string]*twitch.EventSubSubscriptionResponse
+	tokens            map[string]*token
+	users             map[string]*twitch.UserResponse
+	vips              map[string]map[string]bool
+}
+
+// token is an access token issued by the Server.
+type token struct {
+	expiry time.Time
+	login  string
+	scopes []string
+}
+
+// NewServer starts a Server listening on localhost.
+func NewServer() *Server {
+	s := &Server{
+		bans:          make(map[string]map[string]bool),
+		chatters:      make(map[string]map[string]bool),
+		ids:           make(map[string]string),
+		limit:         RateLimit,
+		moderators:    make(map[string]map[string]bool),
+		refresh:       make(map[string]string),
+		sessions:      make(map[string]*session),
+		streams:       make(map[string]time.Time),
+		subscriptions: make(map[string]*twitch.EventSubSubscriptionResponse),
+		tokens:        make(map[string]*token),
+		users:         make(map[string]*twitch.UserResponse),
+		vips:          make(map[string]map[string]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/eventsub", websocket.Handler(s.handleEventSub))
+	mux.HandleFunc("/helix/", s.handleHelix)
+	mux.HandleFunc(twitch.OAuthToken, s.handleToken)
+	mux.HandleFunc(twitch.Validate, s.handleValidate)
+	s.server = httptest.NewServer(mux)
+	s.URL = s.server.URL
+	s.EventSubURL = "ws" + strings.TrimPrefix(s.URL, "http") + "/eventsub"
+	return s
+}
+
+// AddToken issues an access token for the given user. The token can be
+// refreshed with the refresh token, if any, and expires after the given
+// duration unless it is zero.
+func (s *Server) AddToken(access, refresh, login string, expiresIn time.Duration, scopes ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.addUser(login)
+	t := &token{login: login, scopes: scopes}
+
+	if expiresIn > 0 {
+		t.expiry = time.Now().Add(expiresIn)
+	}
+
+	s.tokens[access] = t
+
+	if len(refresh) > 0 {
+		s.refresh[refresh] = login
+	}
+}
+
+// AddUser creates a user with the given login and returns its ID.
+func (s *Server) AddUser(login string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.addUser(login).ID
+}
+
+// addUser returns the user with the given login, creating it if needed.
+func (s *Server) addUser(login string) *twitch.UserResponse {
+	if user, ok := s.users[login]; ok {
+		return user
+	}
+
+	user := &twitch.UserResponse{
+		DisplayName: login,
+		ID:          strconv.Itoa(len(s.users) + 1),
+		Login:       login,
+	}
+
+	s.ids[user.ID] = login
+	s.users[login] = user
+	return user
+}
+
+// Bans returns the sorted users banned from the given channel.
+func (s *Server) Bans(channel string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return sorted(s.bans[channel])
+}
+
+// Close shuts down the Server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Fail responds to the next n Helix requests with the given status code,
+// such as http.StatusServiceUnavailable.
+func (s *Server) Fail(status, n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failStatus = status
+	s.failures = n
+}
+
+// Moderators returns the sorted moderators of the given channel.
+func (s *Server) Moderators(channel string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return sorted(s.moderators[channel])
+}
+
+// Options returns the twitch.Options pointing an API at the Server.
+func (s *Server) Options() []twitch.Option {
+	return []twitch.Option{
+		twitch.WithClient(s.server.Client()),
+		twitch.WithEventSubURL(s.EventSubURL),
+		twitch.WithHelixURL(s.URL + "/helix"),
+		twitch.WithIDURL(s.URL),
+	}
+}
+
+// Revoke invalidates the given access token.
+func (s *Server) Revoke(access string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.tokens, access)
+}
+
+// SetChatters replaces the users present in the chat of the given channel.
+func (s *Server) SetChatters(channel string, chatters ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.chatters[channel] = set(chatters)
+
+	for _, chatter := range chatters {
+		s.addUser(chatter)
+	}
+}
+
+// SetLive starts or ends the stream of the given channel and notifies
+// the EventSub sessions subscribed to it.
+func (s *Server) SetLive(channel string, live bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	user := s.addUser(channel)
+	_, ok := s.streams[channel]
+	broadcaster := twitch.EventBroadcaster{
+		BroadcasterUserID:    user.ID,
+		BroadcasterUserLogin: user.Login,
+		BroadcasterUserName:  user.DisplayName,
+	}
+
+	switch {
+	case !live && ok:
+		delete(s.streams, channel)
+		s.notify(twitch.StreamOffline, channel, twitch.StreamOfflineEvent{EventBroadcaster: broadcaster})
+	case live && !ok:
+		s.streams[channel] = time.Now()
+		s.notify(twitch.StreamOnline, channel, twitch.StreamOnlineEvent{
+			EventBroadcaster: broadcaster,
+			ID:               "stream-" + user.ID,
+			StartedAt:        s.streams[channel].UTC().Format(time.RFC3339),
+			Type:             "live",
+		})
+	}
+}
+
+// SetRateLimit sets the number of Helix requests allowed per minute and
+// refills the bucket.
+func (s *Server) SetRateLimit(limit int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.limit = limit
+	s.reset = time.Time{}
+}
+
+// SetModerators replaces the moderators of the given channel.
+func (s *Server) SetModerators(channel string, moderators ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.moderators[channel] = set(moderators)
+
+	for _, moderator := range moderators {
+		s.addUser(moderator)
+	}
+}
+
+// VIPs returns the sorted VIPs of the given channel.
+func (s *Server) VIPs(channel string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return sorted(s.vips[channel])
+}
+
+// authorize returns the login of the user the request's token belongs to.
+func (s *Server) authorize(r *http.Request) (string, bool) {
+	fields := strings.Fields(r.Header.Get("Authorization"))
+
+	if len(fields) != 2 {
+		return "", false
+	}
+
+	t, ok := s.tokens[fields[1]]
+
+	if !ok || (!t.expiry.IsZero() && time.Now().After(t.expiry)) {
+		return "", false
+	}
+
+	return t.login, true
+}
+
+// handleHelix answers requests to the Helix API.
+func (s *Server) handleHelix(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		writeError(w, s.failStatus, http.StatusText(s.failStatus))
+		return
+	}
+
+	if !s.take(w) {
+		writeError(w, http.StatusTooManyRequests, "Too Many Requests")
+		return
+	}
+
+	login, ok := s.authorize(r)
+
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Invalid OAuth token")
+		return
+	}
+
+	query := r.URL.Query()
+	endpoint := strings.TrimPrefix(r.URL.Path, "/helix")
+
+	// Helix accepts at most 100 IDs or logins per request.
+	for _, key := range []string{"id", "login", "user_id", "user_login"} {
+		if len(query[key]) > 100 {
+			writeError(w, http.StatusBadRequest, "Too many "+key+" parameters")
+			return
+		}
+	}
+
+	switch {
+	case strings.Compare(endpoint, twitch.GetUsers) == 0 && r.Method == http.MethodGet:
+		s.handleUsers(w, login, query["id"], query["login"])
+	case strings.Compare(endpoint, twitch.Streams) == 0 && r.Method == http.MethodGet:
+		s.handleStreams(w, query["user_id"], query["user_login"])
+	case strings.Compare(endpoint, twitch.Moderators) == 0 && r.Method == http.MethodGet:
+		s.handleList(w, query, s.moderators)
+	case strings.Compare(endpoint, twitch.BannedUsers) == 0 && r.Method == http.MethodGet:
+		s.handleList(w, query, s.bans)
+	case strings.Compare(endpoint, twitch.Chatters) == 0 && r.Method == http.MethodGet:
+		s.handleList(w, query, s.chatters)
+	case strings.Compare(endpoint, twitch.Moderators) == 0:
+		s.handleMembership(w, r.Method, login, s.moderators, query.Get("broadcaster_id"), query.Get("user_id"))
+	case strings.Compare(endpoint, twitch.VIPs) == 0:
+		s.handleMembership(w, r.Method, login, s.vips, query.Get("broadcaster_id"), query.Get("user_id"))
+	case strings.Compare(endpoint, twitch.EventSubSubscriptions) == 0:
+		s.handleSubscriptions(w, r)
+	case strings.Compare(endpoint, twitch.Bans) == 0:
+		s.handleBans(w, r, login)
+	case strings.Compare(endpoint, twitch.ChatMessages) == 0 && r.Method == http.MethodDelete:
+		if _, ok := s.ids[query.Get("broadcaster_id")]; !ok {
+			writeError(w, http.StatusBadRequest, "Invalid broadcaster_id")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+// handleBans bans or unbans a user of a channel.
+func (s *Server) handleBans(w http.ResponseWriter, r *http.Request, login string) {
+	query := r.URL.Query()
+	channel, ok := s.ids[query.Get("broadcaster_id")]
+
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid broadcaster_id")
+		return
+	}
+
+	if strings.Compare(s.users[login].ID, query.Get("moderator_id")) != 0 ||
+		(strings.Compare(login, channel) != 0 && !s.moderators[channel][login]) {
+		writeError(w, http.StatusForbidden, "The user in moderator_id is not one of the broadcaster's moderators")
+		return
+	}
+
+	if s.bans[channel] == nil {
+		s.bans[channel] = make(map[string]bool)
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		payload := make(map[string]twitch.BanRequest)
+
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		ban := payload["data"]
+		user, ok := s.ids[ban.UserID]
+
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Invalid user_id")
+			return
+		}
+
+		s.bans[channel][user] = true
+		resp := twitch.BansResponse{Data: []twitch.BanResponse{{
+			BroadcasterID: query.Get("broadcaster_id"),
+			CreatedAt:     time.Now().UTC().Format(time.RFC3339),
+			ModeratorID:   query.Get("moderator_id"),
+			UserID:        ban.UserID,
+		}}}
+
+		if ban.Duration > 0 {
+			resp.Data[0].EndTime = time.Now().Add(time.Duration(ban.Duration) * time.Second).UTC().Format(time.RFC3339)
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	case http.MethodDelete:
+		user := s.ids[query.Get("user_id")]
+
+		if !s.bans[channel][user] {
+			writeError(w, http.StatusBadRequest, "The user in the user_id query parameter is not banned")
+			return
+		}
+
+		delete(s.bans[channel], user)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+	}
+}
+
+// handleMembership adds or removes a user to or from the moderators or
+// VIPs of a channel. Only the broadcaster may change them.
+func (s *Server) handleMembership(w http.ResponseWriter, method, login string, members map[string]map[string]bool, broadcasterID, userID string) {
+	channel, ok := s.ids[broadcasterID]
+
+	if !ok || strings.Compare(channel, login) != 0 {
+		writeError(w, http.StatusUnauthorized, "The ID in broadcaster_id must match the user ID found in the request's OAuth token")
+		return
+	}
+
+	user, ok := s.ids[userID]
+
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid user_id")
+		return
+	}
+
+	if members[channel] == nil {
+		members[channel] = make(map[string]bool)
+	}
+
+	switch method {
+	case http.MethodPost:
+		if members[channel][user] {
+			writeError(w, http.StatusBadRequest, "The user is already a member")
+			return
+		}
+
+		members[channel][user] = true
+	case http.MethodDelete:
+		if !members[channel][user] {
+			writeError(w, http.StatusBadRequest, "The user is not a member")
+			return
+		}
+
+		delete(members[channel], user)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleList answers a page of the moderators, banned users or chatters
+// of a channel, optionally limited to the given user IDs. The cursor is the
+// offset of the page.
+func (s *Server) handleList(w http.ResponseWriter, query url.Values, members map[string]map[string]bool) {
+	channel, ok := s.ids[query.Get("broadcaster_id")]
+
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid broadcaster_id")
+		return
+	}
+
+	logins := sorted(members[channel])
+
+	if ids := query["user_id"]; len(ids) > 0 {
+		filter := make(map[string]bool)
+
+		for _, id := range ids {
+			filter[s.ids[id]] = true
+		}
+
+		logins = logins[:0:0]
+
+		for _, login := range sorted(members[channel]) {
+			if filter[login] {
+				logins = append(logins, login)
+			}
+		}
+	}
+
+	first, err := strconv.Atoi(query.Get("first"))
+
+	if err != nil || first < 1 || first > 100 {
+		first = 20
+	}
+
+	offset, _ := strconv.Atoi(query.Get("after"))
+
+	if offset < 0 || offset > len(logins) {
+		offset = len(logins)
+	}
+
+	end := offset + first
+	cursor := strconv.Itoa(end)
+
+	if end >= len(logins) {
+		end = len(logins)
+		cursor = ""
+	}
+
+	data := make([]map[string]string, 0, end-offset)
+
+	for _, login := range logins[offset:end] {
+		data = append(data, map[string]string{
+			"user_id":    s.users[login].ID,
+			"user_login": login,
+			"user_name":  s.users[login].DisplayName,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":       data,
+		"pagination": twitch.PaginationResponse{Cursor: cursor},
+	})
+}
+
+// handleStreams answers the live streams of the given users.
+func (s *Server) handleStreams(w http.ResponseWriter, ids, logins []string) {
+	data := make([]twitch.StreamResponse, 0)
+
+	for _, id := range ids {
+		logins = append(logins, s.ids[id])
+	}
+
+	for _, login := range logins {
+		startedAt, ok := s.streams[login]
+
+		if !ok {
+			continue
+		}
+
+		data = append(data, twitch.StreamResponse{
+			ID:        "stream-" + s.users[login].ID,
+			StartedAt: startedAt.UTC().Format(time.RFC3339),
+			Type:      "live",
+			UserID:    s.users[login].ID,
+			UserLogin: login,
+			UserName:  s.users[login].DisplayName,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, twitch.Page[twitch.StreamResponse]{Data: data})
+}
+
+// handleToken refreshes an access token.
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	login, ok := s.refresh[r.PostForm.Get("refresh_token")]
+
+	if strings.Compare(r.PostForm.Get("grant_type"), "refresh_token") != 0 || !ok {
+		writeError(w, http.StatusBadRequest, "Invalid refresh token")
+		return
+	}
+
+	delete(s.refresh, r.PostForm.Get("refresh_token"))
+	s.issued++
+	n := strconv.Itoa(s.issued)
+	access, refresh := "access-"+n, "refresh-"+n
+	s.tokens[access] = &token{expiry: time.Now().Add(time.Hour), login: login}
+	s.refresh[refresh] = login
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  access,
+		"expires_in":    3600,
+		"refresh_token": refresh,
+		"token_type":    "bearer",
+	})
+}
+
+// handleUsers answers the users with the given IDs and logins, or the
+// user the token belongs to if none are given.
+func (s *Server) handleUsers(w http.ResponseWriter, login string, ids, logins []string) {
+	resp := twitch.UsersResponse{Data: make([]twitch.UserResponse, 0)}
+
+	if len(ids) == 0 && len(logins) == 0 {
+		logins = []string{login}
+	}
+
+	for _, id := range ids {
+		logins = append(logins, s.ids[id])
+	}
+
+	for _, login := range logins {
+		if user, ok := s.users[login]; ok {
+			resp.Data = append(resp.Data, *user)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleValidate answers the validation of an access token.
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	login, ok := s.authorize(r)
+
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid access token")
+		return
+	}
+
+	t := s.tokens[strings.Fields(r.Header.Get("Authorization"))[1]]
+	resp := twitch.ValidateResponse{
+		Login:  login,
+		Scopes: t.scopes,
+		UserID: s.users[login].ID,
+	}
+
+	if !t.expiry.IsZero() {
+		resp.ExpiresIn = int(time.Until(t.expiry).Seconds())
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// take takes a point from the rate limit bucket and reports the bucket in
+// the headers of the response. It returns false if the bucket is empty.
+func (s *Server) take(w http.ResponseWriter) bool {
+	if now := time.Now(); !now.Before(s.reset) {
+		s.remaining = s.limit
+		s.reset = now.Add(time.Minute)
+	}
+
+	ok := s.remaining > 0
+
+	if ok {
+		s.remaining--
+	}
+
+	w.Header().Set("Ratelimit-Limit", strconv.Itoa(s.limit))
+	w.Header().Set("Ratelimit-Remaining", strconv.Itoa(s.remaining))
+	w.Header().Set("Ratelimit-Reset", strconv.FormatInt(s.reset.Unix(), 10))
+	return ok
+}
+
+// set returns a set of the given values.
+func set(values []string) map[string]bool {
+	s := make(map[string]bool)
+
+	for _, value := range values {
+		s[value] = true
+	}
+
+	return s
+}
+
+// sorted returns the sorted values of a set.
+func sorted(s map[string]bool) []string {
+	values := make([]string, 0, len(s))
+
+	for value := range s {
+		values = append(values, value)
+	}
+
+	sort.Strings(values)
+	return values
+}
+
+// writeError responds with an error like the Twitch API.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, twitch.ErrorResponse{
+		Error:   http.StatusText(status),
+		Message: message,
+		Status:  status,
+	})
+}
+
+// writeJSON responds with the given status and value encoded as JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
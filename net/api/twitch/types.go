@@ -4,6 +4,8 @@ const (
 	// URLs
 	Origin = "http://twitch.tv"
 	IRC    = "ws://irc-ws.chat.twitch.tv:80"
+	IRCTCP = "irc.chat.twitch.tv:6667"
+	IRCTLS = "irc.chat.twitch.tv:6697"
 
 	// Capabilities
 	CommandsCapability   = "twitch.tv/commands"
@@ -11,11 +13,28 @@ const (
 	TagsCapability       = "twitch.tv/tags"
 )
 
-// Chatters contains various roles of chatters in a stream.
-type Chatters struct {
-	Admins     []string `json:"admins`
-	GlobalMods []string `json:"global_mods"`
-	Moderators []string `json:"moderators"`
-	Staff      []string `json:"staff"`
-	Viewers    []string `json:"viewers"`
+// BanRequest contains the user to ban and the reason. A positive Duration
+// in seconds times the user out instead of banning permanently.
+type BanRequest struct {
+	Duration int    `json:"duration,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	UserID   string `json:"user_id"`
+}
+
+// EventSubSubscriptionRequest contains the event to subscribe to, the
+// condition events must match and where notifications are sent.
+type EventSubSubscriptionRequest struct {
+	Condition map[string]string `json:"condition"`
+	Transport EventSubTransport `json:"transport"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+}
+
+// EventSubTransport contains where notifications of a subscription are
+// sent. Only the websocket method with a session ID is used.
+type EventSubTransport struct {
+	ConnectedAt    string `json:"connected_at,omitempty"`
+	DisconnectedAt string `json:"disconnected_at,omitempty"`
+	Method         string `json:"method"`
+	SessionID      string `json:"session_id,omitempty"`
 }
@@ -0,0 +1,173 @@
+package irc
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned when no matching reply arrives in time.
+var ErrTimeout = errors.New("irc: timed out waiting for reply")
+
+// Filter reports whether a message is the reply a caller is waiting for.
+type Filter func(Message) bool
+
+// All returns a Filter matching messages matched by every one of the given filters.
+func All(filters ...Filter) Filter {
+	return func(message Message) bool {
+		for _, filter := range filters {
+			if !filter(message) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// Any returns a Filter matching messages matched by any of the given filters.
+func Any(filters ...Filter) Filter {
+	return func(message Message) bool {
+		for _, filter := range filters {
+			if filter(message) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Commands returns a Filter matching messages with any of the given commands.
+func Commands(commands ...string) Filter {
+	return func(message Message) bool {
+		for _, command := range commands {
+			if strings.Compare(message.Command, command) == 0 {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// MsgIDs returns a Filter matching messages whose msg-id tag is any of the given IDs.
+func MsgIDs(ids ...string) Filter {
+	return func(message Message) bool {
+		id, ok := message.Tags["msg-id"]
+
+		if !ok {
+			return false
+		}
+
+		for _, v := range ids {
+			if strings.Compare(id, v) == 0 {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Target returns a Filter matching messages whose first parameter is the
+// given target, such as a channel.
+func Target(target string) Filter {
+	return func(message Message) bool {
+		return len(message.Params) > 0 && strings.Compare(message.Params[0], target) == 0
+	}
+}
+
+// Correlator hands incoming messages to callers waiting for a reply to a
+// request they sent. Messages nobody is waiting for are left to the
+// regular handlers.
+type Correlator struct {
+	mutex   sync.Mutex
+	waiters []*Waiter
+}
+
+// NewCorrelator creates and initializes a Correlator without waiters.
+func NewCorrelator() *Correlator {
+	return new(Correlator)
+}
+
+// Expect registers interest in the next message matched by filter. Expect
+// should be called before sending the request so that a fast reply is
+// not missed.
+func (c *Correlator) Expect(filter Filter) *Waiter {
+	waiter := &Waiter{
+		correlator: c,
+		filter:     filter,
+		reply:      make(chan Message, 1),
+	}
+
+	c.mutex.Lock()
+	c.waiters = append(c.waiters, waiter)
+	c.mutex.Unlock()
+	return waiter
+}
+
+// Offer delivers the message to the oldest waiter whose filter matches it
+// and returns whether the message was consumed.
+func (c *Correlator) Offer(message Message) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i, waiter := range c.waiters {
+		if waiter.filter(message) {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			waiter.reply <- message
+			return true
+		}
+	}
+
+	return false
+}
+
+// remove unregisters the waiter if it has not received a reply.
+func (c *Correlator) remove(waiter *Waiter) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i, w := range c.waiters {
+		if w == waiter {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Waiter is a pending request registered with a Correlator.
+type Waiter struct {
+	correlator *Correlator
+	filter     Filter
+	reply      chan Message
+}
+
+// Cancel unregisters the waiter without waiting for a reply.
+func (w *Waiter) Cancel() {
+	w.correlator.remove(w)
+}
+
+// Wait blocks until a matching message arrives or the timeout elapses.
+// The waiter is unregistered in either case.
+func (w *Waiter) Wait(timeout time.Duration) (Message, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case message := <-w.reply:
+		return message, nil
+	case <-timer.C:
+		w.Cancel()
+
+		// A reply may have been delivered right before cancelling.
+		select {
+		case message := <-w.reply:
+			return message, nil
+		default:
+			return Message{}, ErrTimeout
+		}
+	}
+}
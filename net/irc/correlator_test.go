@@ -0,0 +1,79 @@
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCorrelatorTimeout(t *testing.T) {
+	c := NewCorrelator()
+	waiter := c.Expect(Commands(RPL_ENDOFMOTD))
+
+	if _, err := waiter.Wait(time.Millisecond); err != ErrTimeout {
+		t.Fatalf("Wait returned %v, want %v", err, ErrTimeout)
+	}
+
+	// A timed out waiter no longer consumes replies.
+	if c.Offer(Message{Command: RPL_ENDOFMOTD}) {
+		t.Error("Reply consumed by a timed out waiter")
+	}
+}
+
+func TestCorrelatorCancel(t *testing.T) {
+	c := NewCorrelator()
+	waiter := c.Expect(Commands("NOTICE"))
+	waiter.Cancel()
+
+	if c.Offer(Message{Command: "NOTICE"}) {
+		t.Error("Reply consumed by a cancelled waiter")
+	}
+
+	// Cancelling a waiter which received its reply is harmless.
+	waiter = c.Expect(Commands("NOTICE"))
+
+	if !c.Offer(Message{Command: "NOTICE"}) {
+		t.Fatal("Reply not consumed")
+	}
+
+	waiter.Cancel()
+
+	if _, err := waiter.Wait(time.Millisecond); err != nil {
+		t.Errorf("Wait after cancelling a delivered reply returned %v", err)
+	}
+}
+
+func TestCorrelatorOrder(t *testing.T) {
+	c := NewCorrelator()
+	first := c.Expect(Commands("NOTICE"))
+	other := c.Expect(All(Commands("NOTICE"), Target("#other")))
+	second := c.Expect(Commands("NOTICE"))
+
+	// Replies go to the oldest waiter whose filter matches them.
+	messages := []Message{
+		{Command: "NOTICE", Params: []string{"#other", "first"}},
+		{Command: "NOTICE", Params: []string{"#other", "other"}},
+		{Command: "NOTICE", Params: []string{"#kneissbot", "second"}},
+	}
+
+	for _, message := range messages {
+		if !c.Offer(message) {
+			t.Fatalf("Reply %q not consumed", message.String())
+		}
+	}
+
+	if c.Offer(Message{Command: "NOTICE"}) {
+		t.Error("Reply consumed without a waiter")
+	}
+
+	for want, waiter := range map[string]*Waiter{"first": first, "other": other, "second": second} {
+		message, err := waiter.Wait(time.Millisecond)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := message.Params[1]; got != want {
+			t.Errorf("Waiter %v received %q", want, got)
+		}
+	}
+}
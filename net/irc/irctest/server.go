@@ -0,0 +1,458 @@
+package irctest
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kookehs/kneissbot/net/api/twitch"
+	"github.com/kookehs/kneissbot/net/irc"
+	"golang.org/x/net/websocket"
+)
+
+// Host is the server name used as the prefix of server replies.
+const Host = "tmi.twitch.tv"
+
+// Capabilities supported by the Server.
+var Capabilities = []string{
+	twitch.CommandsCapability,
+	twitch.MembershipCapability,
+	twitch.TagsCapability,
+}
+
+// Server is an in-process IRC server which answers like Twitch IRC. It
+// listens on localhost over plain TCP or a websocket and keeps its own
+// list of moderators per channel. Server is intended for tests.
+type Server struct {
+	Addr string
+
+	clients     map[*client]bool
+	connections []*client
+	listener    net.Listener
+	messages    []irc.Message
+	moderators  map[string]map[string]bool
+	mutex       sync.Mutex
+	server      *http.Server
+}
+
+// NewServer starts a Server accepting plain TCP connections.
+func NewServer() (*Server, error) {
+	s, err := newServer()
+
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go s.serve(s.accept(conn))
+		}
+	}()
+
+	return s, nil
+}
+
+// NewWebsocketServer starts a Server accepting websocket connections.
+func NewWebsocketServer() (*Server, error) {
+	s, err := newServer()
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.server = &http.Server{
+		Handler: websocket.Handler(func(ws *websocket.Conn) {
+			s.serve(s.accept(ws))
+		}),
+	}
+
+	go s.server.Serve(s.listener)
+	return s, nil
+}
+
+func newServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		Addr:       listener.Addr().String(),
+		clients:    make(map[*client]bool),
+		listener:   listener,
+		moderators: make(map[string]map[string]bool),
+	}, nil
+}
+
+// Close stops listening and disconnects every client.
+func (s *Server) Close() error {
+	var err error
+
+	if s.server != nil {
+		err = s.server.Close()
+	} else {
+		err = s.listener.Close()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for c := range s.clients {
+		c.conn.Close()
+	}
+
+	return err
+}
+
+// Connections returns the messages received over every connection in the
+// order the connections were accepted.
+func (s *Server) Connections() [][]irc.Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	connections := make([][]irc.Message, 0, len(s.connections))
+
+	for _, c := range s.connections {
+		connections = append(connections, append([]irc.Message(nil), c.messages...))
+	}
+
+	return connections
+}
+
+// Disconnect drops every client connection while continuing to accept new ones.
+func (s *Server) Disconnect() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for c := range s.clients {
+		c.conn.Close()
+	}
+}
+
+// Messages returns every message received from clients in order.
+func (s *Server) Messages() []irc.Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]irc.Message(nil), s.messages...)
+}
+
+// Moderators returns the sorted moderators of the given channel.
+func (s *Server) Moderators(channel string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	moderators := make([]string, 0, len(s.moderators[channel]))
+
+	for moderator := range s.moderators[channel] {
+		moderators = append(moderators, moderator)
+	}
+
+	sort.Strings(moderators)
+	return moderators
+}
+
+// SetModerators replaces the moderators of the given channel.
+func (s *Server) SetModerators(channel string, moderators ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.moderators[channel] = make(map[string]bool)
+
+	for _, moderator := range moderators {
+		s.moderators[channel][moderator] = true
+	}
+}
+
+// Send writes a raw line to every connected client. It is used to push
+// server initiated messages such as RECONNECT or chat from other users.
+func (s *Server) Send(line string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for c := range s.clients {
+		c.writeLine(line)
+	}
+}
+
+// Transport returns a Transport connecting to the Server.
+func (s *Server) Transport() irc.Transport {
+	if s.server != nil {
+		return &irc.WebsocketTransport{Origin: "http://localhost/", URL: "ws://" + s.Addr + "/"}
+	}
+
+	return &irc.TCPTransport{Address: s.Addr}
+}
+
+// client is a connection to the Server.
+type client struct {
+	caps        map[string]bool
+	conn        io.ReadWriteCloser
+	messages    []irc.Message
+	mutex       sync.Mutex
+	negotiating bool
+	nick        string
+	pass        string
+	registered  bool
+}
+
+// write sends a message from the given prefix, including the tags only
+// if the client negotiated the tags capability.
+func (c *client) write(prefix string, tags map[string]string, command string, params ...string) {
+	message := irc.Message{
+		Command: command,
+		Params:  params,
+		Prefix:  irc.MakePrefix(prefix),
+	}
+
+	if c.caps[twitch.TagsCapability] {
+		message.Tags = tags
+	}
+
+	c.writeLine(message.String())
+}
+
+func (c *client) writeLine(line string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, err := io.WriteString(c.conn, line+"\r\n"); err != nil {
+		log.Println(err)
+	}
+}
+
+// accept registers a new client connection.
+func (s *Server) accept(conn io.ReadWriteCloser) *client {
+	c := &client{
+		caps: make(map[string]bool),
+		conn: conn,
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.clients[c] = true
+	s.connections = append(s.connections, c)
+	return c
+}
+
+// serve answers the messages of a client until it disconnects.
+func (s *Server) serve(c *client) {
+	defer func() {
+		s.mutex.Lock()
+		delete(s.clients, c)
+		s.mutex.Unlock()
+		c.conn.Close()
+	}()
+
+	reader := irc.NewLineReader(c.conn)
+
+	for {
+		line, err := reader.ReadLine()
+
+		if err != nil {
+			return
+		}
+
+		message, err := irc.MakeMessage(string(line))
+
+		if err != nil {
+			continue
+		}
+
+		s.mutex.Lock()
+		c.messages = append(c.messages, message)
+		s.messages = append(s.messages, message)
+		s.mutex.Unlock()
+		s.handle(c, message)
+	}
+}
+
+// handle answers a single message of a client.
+func (s *Server) handle(c *client, message irc.Message) {
+	switch message.Command {
+	case "CAP":
+		s.handleCap(c, message)
+	case "JOIN":
+		for _, channel := range strings.Split(param(message, 0), ",") {
+			user := c.nick + "!" + c.nick + "@" + c.nick + "." + Host
+			c.write(user, nil, "JOIN", channel)
+			c.write(c.nick+"."+Host, nil, irc.RPL_NAMREPLY, c.nick, "=", channel, c.nick)
+			c.write(c.nick+"."+Host, nil, irc.RPL_ENDOFNAMES, c.nick, channel, "End of /NAMES list")
+			c.write(Host, map[string]string{"badges": s.badges(c.nick, channel), "display-name": c.nick, "mod": s.mod(c.nick, channel)}, "USERSTATE", channel)
+			c.write(Host, map[string]string{"emote-only": "0", "followers-only": "-1", "r9k": "0", "slow": "0", "subs-only": "0"}, "ROOMSTATE", channel)
+		}
+	case "NICK":
+		c.nick = param(message, 0)
+		c.registered = false
+
+		// Registration is suspended until capability negotiation ends.
+		if !c.negotiating {
+			s.register(c)
+		}
+	case "PART":
+		user := c.nick + "!" + c.nick + "@" + c.nick + "." + Host
+		c.write(user, nil, "PART", param(message, 0))
+	case "PASS":
+		c.pass = param(message, 0)
+	case "PING":
+		c.write("", nil, "PONG", Host, param(message, 0))
+	case "PRIVMSG":
+		s.handleChat(c, param(message, 0), param(message, 1))
+	}
+}
+
+// handleCap answers capability negotiation. A request is acknowledged
+// only if every requested capability is supported.
+func (s *Server) handleCap(c *client, message irc.Message) {
+	switch param(message, 0) {
+	case "END":
+		c.negotiating = false
+
+		if len(c.nick) > 0 && !c.registered {
+			s.register(c)
+		}
+	case "LS":
+		c.negotiating = !c.registered
+		c.write(Host, nil, "CAP", "*", "LS", strings.Join(Capabilities, " "))
+	case "REQ":
+		requested := strings.Fields(param(message, 1))
+
+		for _, capability := range requested {
+			if !supported(capability) {
+				c.write(Host, nil, "CAP", "*", "NAK", param(message, 1))
+				return
+			}
+		}
+
+		for _, capability := range requested {
+			c.caps[capability] = true
+		}
+
+		c.write(Host, nil, "CAP", "*", "ACK", param(message, 1))
+	}
+}
+
+// register welcomes a client that sent its nickname or sends a notice of
+// failed authentication.
+func (s *Server) register(c *client) {
+	if !strings.HasPrefix(c.pass, "oauth:") || len(c.pass) == len("oauth:") {
+		c.write(Host, nil, "NOTICE", "*", "Login authentication failed")
+		return
+	}
+
+	c.registered = true
+	c.write(Host, nil, irc.RPL_WELCOME, c.nick, "Welcome, GLHF!")
+	c.write(Host, nil, irc.RPL_YOURHOST, c.nick, "Your host is "+Host)
+	c.write(Host, nil, irc.RPL_CREATED, c.nick, "This server is rather new")
+	c.write(Host, nil, irc.RPL_MYINFO, c.nick, "-")
+	c.write(Host, nil, irc.RPL_MOTDSTART, c.nick, "-")
+	c.write(Host, nil, irc.RPL_MOTD, c.nick, "You are in a maze of twisty passages, all alike.")
+	c.write(Host, nil, irc.RPL_ENDOFMOTD, c.nick, ">")
+}
+
+// handleChat answers the /mods, /mod and /unmod chat commands with the
+// notices Twitch sends. Only the broadcaster may add or remove moderators.
+func (s *Server) handleChat(c *client, channel, text string) {
+	name := strings.TrimPrefix(channel, "#")
+	fields := strings.Fields(text)
+
+	if len(fields) == 0 {
+		return
+	}
+
+	notice := func(id, text string) {
+		c.write(Host, map[string]string{"msg-id": id}, "NOTICE", channel, text)
+	}
+
+	switch fields[0] {
+	case "/mods":
+		if moderators := s.Moderators(name); len(moderators) > 0 {
+			notice("room_mods", "The moderators of this channel are: "+strings.Join(moderators, ", "))
+		} else {
+			notice("no_mods", "There are no moderators of this channel.")
+		}
+	case "/mod", "/unmod":
+		if len(fields) < 2 {
+			notice("usage_"+fields[0][1:], "Usage: "+fields[0]+" <username>")
+			return
+		}
+
+		if strings.Compare(c.nick, name) != 0 {
+			notice("no_permission", "You don't have permission to perform that action.")
+			return
+		}
+
+		user := strings.ToLower(fields[1])
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		if s.moderators[name] == nil {
+			s.moderators[name] = make(map[string]bool)
+		}
+
+		mod := s.moderators[name][user]
+
+		switch {
+		case fields[0] == "/mod" && mod:
+			notice("bad_mod_mod", user+" is already a moderator of this channel.")
+		case fields[0] == "/mod":
+			s.moderators[name][user] = true
+			notice("mod_success", "You have added "+user+" as a moderator of this channel.")
+		case !mod:
+			notice("bad_unmod_mod", user+" is not a moderator of this channel.")
+		default:
+			delete(s.moderators[name], user)
+			notice("unmod_success", "You have removed "+user+" as a moderator of this channel.")
+		}
+	}
+}
+
+// badges returns the badges tag of the user in the channel.
+func (s *Server) badges(user, channel string) string {
+	if strings.Compare("#"+user, channel) == 0 {
+		return "broadcaster/1"
+	}
+
+	if strings.Compare(s.mod(user, channel), "1") == 0 {
+		return "moderator/1"
+	}
+
+	return ""
+}
+
+// mod returns the mod tag of the user in the channel.
+func (s *Server) mod(user, channel string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.moderators[strings.TrimPrefix(channel, "#")][user] {
+		return "1"
+	}
+
+	return "0"
+}
+
+func param(message irc.Message, i int) string {
+	if i < len(message.Params) {
+		return message.Params[i]
+	}
+
+	return ""
+}
+
+func supported(capability string) bool {
+	for _, v := range Capabilities {
+		if strings.Compare(v, capability) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
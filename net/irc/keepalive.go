@@ -0,0 +1,97 @@
+package irc
+
+import (
+	"bytes"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// PingToken prefixes the token of PING messages sent by the Session.
+const PingToken = "kneissbot-"
+
+var (
+	// PingInterval is the time between PING messages sent to the IRC server.
+	PingInterval = time.Minute
+	// SilenceTimeout is the time without any incoming data after which the
+	// connection is considered dead and the Session reconnects.
+	SilenceTimeout = 3 * time.Minute
+)
+
+// Latency returns the round-trip time of the last answered PING.
+func (s *Session) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.latency))
+}
+
+// keepalive pings the IRC server every PingInterval on the Clock of the
+// Session and reconnects once the connection has been silent for longer
+// than SilenceTimeout. A half-open connection otherwise goes unnoticed as
+// reads never fail. Pinging stops once the Session is closed.
+func (s *Session) keepalive() {
+	s.Clock.AfterFunc(PingInterval, func() {
+		if s.Closed() {
+			return
+		}
+
+		s.ping()
+		s.keepalive()
+	})
+}
+
+// ping sends a PING to the IRC server or reconnects if the connection has
+// been silent for too long.
+func (s *Session) ping() {
+	silence := s.Clock.Now().Sub(time.Unix(0, atomic.LoadInt64(&s.lastRead)))
+
+	if silence > SilenceTimeout {
+		log.Printf("[IRC]: Connection silent for %v, reconnecting", silence.Truncate(time.Second))
+		s.touch()
+
+		if err := s.Reconnect(); err != nil {
+			log.Println(err)
+		}
+
+		return
+	}
+
+	token := PingToken + strconv.FormatInt(s.Clock.Now().UnixNano(), 10)
+	s.WritePriority("PING :"+token, PriorityProtocol)
+}
+
+// pong measures the latency if the line answers a PING sent by keepalive
+// and returns whether it did.
+func (s *Session) pong(line []byte) bool {
+	if !bytes.Contains(line, []byte(PingToken)) {
+		return false
+	}
+
+	message, err := MakeMessage(string(line))
+
+	if err != nil || message.Command != "PONG" || len(message.Params) == 0 {
+		return false
+	}
+
+	token := message.Params[len(message.Params)-1]
+
+	if !strings.HasPrefix(token, PingToken) {
+		return false
+	}
+
+	sent, err := strconv.ParseInt(token[len(PingToken):], 10, 64)
+
+	if err != nil {
+		return false
+	}
+
+	latency := s.Clock.Now().Sub(time.Unix(0, sent))
+	atomic.StoreInt64(&s.latency, int64(latency))
+	log.Printf("[IRC]: Latency - %v", latency)
+	return true
+}
+
+// touch records that data was just received.
+func (s *Session) touch() {
+	atomic.StoreInt64(&s.lastRead, s.Clock.Now().UnixNano())
+}
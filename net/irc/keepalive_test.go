@@ -0,0 +1,148 @@
+package irc
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kookehs/kneissbot/time/clock"
+)
+
+// discard is a Handler ignoring every message.
+type discard struct{}
+
+func (discard) In(input []byte) {}
+
+// pipeServer reads the lines a Session writes to the server end of a pipe.
+type pipeServer struct {
+	conn  net.Conn
+	lines []string
+	mutex sync.Mutex
+}
+
+func newPipeServer(conn net.Conn) *pipeServer {
+	ps := &pipeServer{conn: conn}
+
+	go func() {
+		scanner := bufio.NewScanner(conn)
+
+		for scanner.Scan() {
+			ps.mutex.Lock()
+			ps.lines = append(ps.lines, scanner.Text())
+			ps.mutex.Unlock()
+		}
+	}()
+
+	return ps
+}
+
+// pings returns the number of PING messages received.
+func (ps *pipeServer) pings() int {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	n := 0
+
+	for _, line := range ps.lines {
+		if strings.HasPrefix(line, "PING :"+PingToken) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// newPipeSession returns a Session on a PipeTransport listening with a
+// fake clock along with the server end of its connection.
+func newPipeSession(t *testing.T) (*Session, *PipeTransport, *clock.Fake, *pipeServer) {
+	transport := NewPipeTransport()
+	session, err := NewSession(transport)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { session.Close() })
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+	session.Clock = fake
+	server := newPipeServer(<-transport.Server)
+	go session.Listen(discard{})
+
+	// Keepalives are scheduled by the time the first line is read.
+	server.conn.Write([]byte(":tmi.twitch.tv 001 kneissbot :Welcome, GLHF!\r\n"))
+	deadline := time.Now().Add(time.Second)
+
+	for !session.Connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("Session not welcomed")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	return session, transport, fake, server
+}
+
+func TestKeepalive(t *testing.T) {
+	_, transport, fake, server := newPipeSession(t)
+
+	// A silent connection is pinged until SilenceTimeout has passed.
+	fake.Advance(SilenceTimeout)
+
+	select {
+	case <-transport.Server:
+		t.Fatal("Reconnected before SilenceTimeout")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	want := int(SilenceTimeout / PingInterval)
+	deadline := time.Now().Add(time.Second)
+
+	for server.pings() < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("Server received %v pings, want %v", server.pings(), want)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	fake.Advance(PingInterval)
+
+	select {
+	case conn := <-transport.Server:
+		conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("Silent connection not reconnected")
+	}
+}
+
+func TestKeepaliveLatency(t *testing.T) {
+	session, _, fake, server := newPipeSession(t)
+	fake.Advance(PingInterval)
+	deadline := time.Now().Add(time.Second)
+
+	for server.pings() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Server not pinged")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Answers to pings are measured rather than passed to the handler.
+	server.mutex.Lock()
+	token := strings.TrimPrefix(server.lines[len(server.lines)-1], "PING :")
+	server.mutex.Unlock()
+	fake.Advance(250 * time.Millisecond)
+	server.conn.Write([]byte(":tmi.twitch.tv PONG tmi.twitch.tv :" + token + "\r\n"))
+
+	for session.Latency() != 250*time.Millisecond {
+		if time.Now().After(deadline) {
+			t.Fatalf("Latency() = %v, want %v", session.Latency(), 250*time.Millisecond)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
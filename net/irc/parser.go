@@ -1,20 +1,30 @@
 package irc
 
 import (
-	"regexp"
+	"bytes"
+	"errors"
+	"io"
+	"sort"
 	"strings"
 )
 
-// Regular Expressions for searching
-const (
-	CommandFormat = `(?:(?P<Command>[a-zA-Z]+)|(?P<Command>\d{3}))`
-	ParamFormat   = `(?P<Params>\s(?::(?P<Trailing>.*)|(?P<Middle>[^:]\w+)))*`
-	PrefixFormat  = `:(?P<Prefix>(?P<Name>\S+?)(?:!(?P<User>\S+?))?(?:@(?P<Host>\S+?))?\s)?`
-	TagFormat     = `(?:@(?P<Tags>\S+)\s)?`
+// Errors returned when parsing a Message.
+var (
+	ErrEmptyMessage   = errors.New("irc: message is empty")
+	ErrInvalidCommand = errors.New("irc: command is not a word or a three digit numeric")
+	ErrMissingCommand = errors.New("irc: message ends before the command")
 )
 
-// MessageRegExp is used to search for components of an IRC message through capture groups.
-var MessageRegExp = regexp.MustCompile(TagFormat + PrefixFormat + CommandFormat + ParamFormat)
+// forbidden are the characters no part of an encoded Message may contain.
+const forbidden = "\r\n\x00"
+
+// Errors returned when encoding a Message.
+var (
+	ErrEmptyCommand  = errors.New("irc: message has no command")
+	ErrInvalidParam  = errors.New("irc: parameter contains a line break or NUL, or middle parameter contains a space, starts with a colon or is empty")
+	ErrInvalidPrefix = errors.New("irc: prefix contains a space, a line break or NUL")
+	ErrInvalidTag    = errors.New("irc: tag key is empty or contains reserved characters")
+)
 
 // Message is a RFC1459 message in the format specified by the protocol with support for IRCv3 tags.
 // <message> ::= ['@' <tags> <SPACE>] [':' <prefix> <SPACE> ] <command> <params> <crlf>
@@ -25,46 +35,167 @@ type Message struct {
 	Tags    map[string]string
 }
 
-// MakeMessage creates a Message from the given string.
-func MakeMessage(input string) Message {
-	result := MessageRegExp.FindStringSubmatch(input)
-	names := MessageRegExp.SubexpNames()
+// MakeMessage creates a Message from the given string. Any trailing line
+// ending is ignored. An error is returned if the input is not a well formed
+// message.
+// <params> ::= <SPACE> [ ':' <trailing> | <middle> <params> ]
+func MakeMessage(input string) (Message, error) {
 	message := Message{
-		Params: make([]string, 0),
-		Prefix: Prefix{},
+		Params: make([]string, 0, 2),
 		Tags:   make(map[string]string),
 	}
 
-	for i, v := range result {
-		switch names[i] {
-		// Commands
-		case "Command":
-			if len(message.Command) == 0 {
-				message.Command = v
-			}
-		// Params
-		case "Middle":
-			fallthrough
-		case "Trailing":
-			if len(v) != 0 {
-				message.Params = append(message.Params, v)
-			}
-		// Prefix
-		case "Host":
-			message.Prefix.Host = v
-		case "Name":
-			message.Prefix.Name = v
-		case "User":
-			message.Prefix.User = v
-		// Tags
-		case "Tags":
-			if len(v) != 0 {
-				message.Tags = MakeTags(v)
-			}
+	line := strings.TrimRight(input, "\r\n")
+
+	if len(line) == 0 {
+		return message, ErrEmptyMessage
+	}
+
+	var token string
+
+	if line[0] == '@' {
+		token, line = nextToken(line[1:])
+		message.Tags = MakeTags(token)
+	}
+
+	if len(line) > 0 && line[0] == ':' {
+		token, line = nextToken(line[1:])
+		message.Prefix = MakePrefix(token)
+	}
+
+	message.Command, line = nextToken(line)
+
+	if len(message.Command) == 0 {
+		return message, ErrMissingCommand
+	}
+
+	if !validCommand(message.Command) {
+		return message, ErrInvalidCommand
+	}
+
+	for len(line) > 0 {
+		if line[0] == ':' {
+			message.Params = append(message.Params, line[1:])
+			break
+		}
+
+		token, line = nextToken(line)
+		message.Params = append(message.Params, token)
+	}
+
+	return message, nil
+}
+
+// nextToken returns the input up to the first space and the remainder
+// with any leading spaces removed.
+func nextToken(input string) (string, string) {
+	end := strings.IndexByte(input, ' ')
+
+	if end == -1 {
+		return input, ""
+	}
+
+	token := input[:end]
+
+	for end < len(input) && input[end] == ' ' {
+		end++
+	}
+
+	return token, input[end:]
+}
+
+// validCommand returns whether the command is made up of letters only or
+// is a three digit numeric reply.
+// <command> ::= <letter> { <letter> } | <number> <number> <number>
+func validCommand(command string) bool {
+	if len(command) == 3 && isDigit(command[0]) && isDigit(command[1]) && isDigit(command[2]) {
+		return true
+	}
+
+	for i := 0; i < len(command); i++ {
+		if c := command[i]; !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			return false
 		}
 	}
 
-	return message
+	return true
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// Encode writes the Message to w in the wire format after checking that
+// it can be represented as a single line. The line ending is not written.
+func (m Message) Encode(w io.Writer) error {
+	if len(m.Command) == 0 {
+		return ErrEmptyCommand
+	}
+
+	if !validCommand(m.Command) {
+		return ErrInvalidCommand
+	}
+
+	if strings.ContainsAny(m.Prefix.String(), " "+forbidden) {
+		return ErrInvalidPrefix
+	}
+
+	for key := range m.Tags {
+		if len(key) == 0 || strings.ContainsAny(key, " ;=\r\n") {
+			return ErrInvalidTag
+		}
+	}
+
+	for i, param := range m.Params {
+		// Line breaks would end the message and start another one.
+		if strings.ContainsAny(param, forbidden) {
+			return ErrInvalidParam
+		}
+
+		if i == len(m.Params)-1 {
+			break
+		}
+
+		if len(param) == 0 || param[0] == ':' || strings.IndexByte(param, ' ') != -1 {
+			return ErrInvalidParam
+		}
+	}
+
+	_, err := io.WriteString(w, m.String())
+	return err
+}
+
+// String returns the Message in the wire format without the line ending.
+// The last parameter is always written as a trailing parameter when it
+// is empty, contains a space or begins with a colon.
+func (m Message) String() string {
+	var buffer bytes.Buffer
+
+	if len(m.Tags) > 0 {
+		buffer.WriteByte('@')
+		buffer.WriteString(EncodeTags(m.Tags))
+		buffer.WriteByte(' ')
+	}
+
+	if prefix := m.Prefix.String(); len(prefix) > 0 {
+		buffer.WriteByte(':')
+		buffer.WriteString(prefix)
+		buffer.WriteByte(' ')
+	}
+
+	buffer.WriteString(m.Command)
+
+	for i, param := range m.Params {
+		buffer.WriteByte(' ')
+
+		if i == len(m.Params)-1 && (len(param) == 0 || param[0] == ':' || strings.IndexByte(param, ' ') != -1) {
+			buffer.WriteByte(':')
+		}
+
+		buffer.WriteString(param)
+	}
+
+	return buffer.String()
 }
 
 // Prefix is an optional structure within a Message.
@@ -76,8 +207,44 @@ type Prefix struct {
 	User string
 }
 
+// String returns the Prefix in the format <nick> [ '!' <user> ] [ '@' <host> ].
+func (p Prefix) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(p.Name)
+
+	if len(p.User) > 0 {
+		buffer.WriteByte('!')
+		buffer.WriteString(p.User)
+	}
+
+	if len(p.Host) > 0 {
+		buffer.WriteByte('@')
+		buffer.WriteString(p.Host)
+	}
+
+	return buffer.String()
+}
+
+// MakePrefix creates a Prefix from the given string.
+func MakePrefix(input string) Prefix {
+	prefix := Prefix{}
+
+	if at := strings.IndexByte(input, '@'); at != -1 {
+		prefix.Host = input[at+1:]
+		input = input[:at]
+	}
+
+	if bang := strings.IndexByte(input, '!'); bang != -1 {
+		prefix.User = input[bang+1:]
+		input = input[:bang]
+	}
+
+	prefix.Name = input
+	return prefix
+}
+
 // MakeTags creates a Tags from the given string.
-// Tags are the IRCv3 message tags.
+// Tags are the IRCv3 message tags. Values are unescaped.
 // <tags>	::= <tag> [';' <tag>]*
 // <tag>	::= <key> ['=' <escaped value>]
 func MakeTags(input string) map[string]string {
@@ -85,6 +252,10 @@ func MakeTags(input string) map[string]string {
 	pairs := strings.Split(input, ";")
 
 	for _, v := range pairs {
+		if len(v) == 0 {
+			continue
+		}
+
 		pair := strings.SplitN(v, "=", 2)
 
 		if len(pair) == 1 {
@@ -92,8 +263,103 @@ func MakeTags(input string) map[string]string {
 			continue
 		}
 
-		tags[pair[0]] = pair[1]
+		tags[pair[0]] = UnescapeTag(pair[1])
 	}
 
 	return tags
 }
+
+// EncodeTags returns the given tags in the wire format with escaped values.
+// Keys are sorted so that the output is deterministic.
+func EncodeTags(tags map[string]string) string {
+	var buffer bytes.Buffer
+	keys := make([]string, 0, len(tags))
+
+	for key := range tags {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for i, key := range keys {
+		if i != 0 {
+			buffer.WriteByte(';')
+		}
+
+		buffer.WriteString(key)
+
+		if value := tags[key]; len(value) > 0 {
+			buffer.WriteByte('=')
+			buffer.WriteString(EscapeTag(value))
+		}
+	}
+
+	return buffer.String()
+}
+
+// EscapeTag escapes a tag value as specified by IRCv3 message tags.
+func EscapeTag(value string) string {
+	if !strings.ContainsAny(value, "; \\\r\n") {
+		return value
+	}
+
+	var buffer bytes.Buffer
+
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case ';':
+			buffer.WriteString(`\:`)
+		case ' ':
+			buffer.WriteString(`\s`)
+		case '\\':
+			buffer.WriteString(`\\`)
+		case '\r':
+			buffer.WriteString(`\r`)
+		case '\n':
+			buffer.WriteString(`\n`)
+		default:
+			buffer.WriteByte(value[i])
+		}
+	}
+
+	return buffer.String()
+}
+
+// UnescapeTag reverses the escaping of a tag value as specified by IRCv3
+// message tags. An unknown escape yields the escaped character and a
+// trailing backslash is dropped.
+func UnescapeTag(value string) string {
+	if strings.IndexByte(value, '\\') == -1 {
+		return value
+	}
+
+	var buffer bytes.Buffer
+
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			buffer.WriteByte(value[i])
+			continue
+		}
+
+		i++
+
+		if i == len(value) {
+			break
+		}
+
+		switch value[i] {
+		case ':':
+			buffer.WriteByte(';')
+		case 's':
+			buffer.WriteByte(' ')
+		case 'r':
+			buffer.WriteByte('\r')
+		case 'n':
+			buffer.WriteByte('\n')
+		default:
+			buffer.WriteByte(value[i])
+		}
+	}
+
+	return buffer.String()
+}
@@ -0,0 +1,219 @@
+package irc
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMakeMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Message
+	}{
+		{
+			name:  "tags with escapes",
+			input: `@badge-info=;display-name=Foo\sBar;msg=a\:b\\c\r\n;emote-only;trailing=x\ :foo!foo@foo.tmi.twitch.tv PRIVMSG #chan :hello world` + "\r\n",
+			want: Message{
+				Command: "PRIVMSG",
+				Params:  []string{"#chan", "hello world"},
+				Prefix:  Prefix{Host: "foo.tmi.twitch.tv", Name: "foo", User: "foo"},
+				Tags: map[string]string{
+					"badge-info":   "",
+					"display-name": "Foo Bar",
+					"emote-only":   "",
+					"msg":          "a;b\\c\r\n",
+					"trailing":     "x",
+				},
+			},
+		},
+		{
+			name:  "no prefix",
+			input: "PING :tmi.twitch.tv",
+			want: Message{
+				Command: "PING",
+				Params:  []string{"tmi.twitch.tv"},
+				Tags:    map[string]string{},
+			},
+		},
+		{
+			name:  "several middle params",
+			input: ":tmi.twitch.tv 353 kneissbot = #chan :a b c",
+			want: Message{
+				Command: "353",
+				Params:  []string{"kneissbot", "=", "#chan", "a b c"},
+				Prefix:  Prefix{Name: "tmi.twitch.tv"},
+				Tags:    map[string]string{},
+			},
+		},
+		{
+			name:  "middle params only",
+			input: "JOIN  #chan   #other",
+			want: Message{
+				Command: "JOIN",
+				Params:  []string{"#chan", "#other"},
+				Tags:    map[string]string{},
+			},
+		},
+		{
+			name:  "empty trailing",
+			input: "PRIVMSG #chan :",
+			want: Message{
+				Command: "PRIVMSG",
+				Params:  []string{"#chan", ""},
+				Tags:    map[string]string{},
+			},
+		},
+		{
+			name:  "trailing with colon",
+			input: "PRIVMSG #chan ::) hi",
+			want: Message{
+				Command: "PRIVMSG",
+				Params:  []string{"#chan", ":) hi"},
+				Tags:    map[string]string{},
+			},
+		},
+		{
+			name:  "no params",
+			input: ":tmi.twitch.tv RECONNECT",
+			want: Message{
+				Command: "RECONNECT",
+				Params:  []string{},
+				Prefix:  Prefix{Name: "tmi.twitch.tv"},
+				Tags:    map[string]string{},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := MakeMessage(test.input)
+
+			if err != nil {
+				t.Fatalf("MakeMessage(%q) returned error %v", test.input, err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("MakeMessage(%q)\ngot  %#v\nwant %#v", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMakeMessageMalformed(t *testing.T) {
+	tests := []struct {
+		input string
+		want  error
+	}{
+		{"", ErrEmptyMessage},
+		{"\r\n", ErrEmptyMessage},
+		{"@badge-info=", ErrMissingCommand},
+		{"@badge-info= :foo!foo@foo", ErrMissingCommand},
+		{":tmi.twitch.tv", ErrMissingCommand},
+		{":tmi.twitch.tv ", ErrMissingCommand},
+		{"12 #chan", ErrInvalidCommand},
+		{"1234 #chan", ErrInvalidCommand},
+		{"PRIV1MSG #chan", ErrInvalidCommand},
+		{":tmi.twitch.tv PRIV-MSG #chan :hi", ErrInvalidCommand},
+	}
+
+	for _, test := range tests {
+		if _, err := MakeMessage(test.input); err != test.want {
+			t.Errorf("MakeMessage(%q) returned error %v, want %v", test.input, err, test.want)
+		}
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	inputs := []string{
+		`@display-name=Foo\sBar;msg=a\:b\\c :foo!foo@foo.tmi.twitch.tv PRIVMSG #chan :hello world`,
+		":tmi.twitch.tv 353 kneissbot = #chan :a b c",
+		"PRIVMSG #chan :",
+		"PRIVMSG #chan ::)",
+		"PONG tmi.twitch.tv",
+	}
+
+	for _, input := range inputs {
+		message, err := MakeMessage(input)
+
+		if err != nil {
+			t.Fatalf("MakeMessage(%q) returned error %v", input, err)
+		}
+
+		if got := message.String(); got != input {
+			t.Errorf("MakeMessage(%q).String() = %q", input, got)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		message Message
+		want    error
+	}{
+		{"valid", Message{Command: "PRIVMSG", Params: []string{"#chan", "hello world"}}, nil},
+		{"numeric", Message{Command: RPL_ENDOFMOTD, Params: []string{"kneissbot", ">"}}, nil},
+		{"no command", Message{Params: []string{"#chan"}}, ErrEmptyCommand},
+		{"invalid command", Message{Command: "PRIVMSG #chan"}, ErrInvalidCommand},
+		{"command with line break", Message{Command: "PING\r\nQUIT"}, ErrInvalidCommand},
+		{"trailing with line break", Message{Command: "PRIVMSG", Params: []string{"#chan", "hi\r\nPRIVMSG #chan :injected"}}, ErrInvalidParam},
+		{"trailing with newline", Message{Command: "PRIVMSG", Params: []string{"#chan", "hi\nQUIT"}}, ErrInvalidParam},
+		{"trailing with NUL", Message{Command: "PRIVMSG", Params: []string{"#chan", "hi\x00"}}, ErrInvalidParam},
+		{"middle with line break", Message{Command: "JOIN", Params: []string{"#chan\r\nQUIT", "key"}}, ErrInvalidParam},
+		{"middle with space", Message{Command: "PRIVMSG", Params: []string{"#chan #other", "hi"}}, ErrInvalidParam},
+		{"empty middle", Message{Command: "PRIVMSG", Params: []string{"", "hi"}}, ErrInvalidParam},
+		{"prefix with line break", Message{Command: "PING", Prefix: Prefix{Name: "tmi\r\nQUIT"}}, ErrInvalidPrefix},
+		{"prefix with space", Message{Command: "PING", Prefix: Prefix{Name: "tmi", Host: "a b"}}, ErrInvalidPrefix},
+		{"tag key with line break", Message{Command: "PING", Tags: map[string]string{"a\nb": ""}}, ErrInvalidTag},
+	}
+
+	for _, test := range tests {
+		var buffer bytes.Buffer
+		err := test.message.Encode(&buffer)
+
+		if err != test.want {
+			t.Errorf("%v: Encode returned %v, want %v", test.name, err, test.want)
+		}
+
+		if err != nil && buffer.Len() > 0 {
+			t.Errorf("%v: Encode wrote %q despite the error", test.name, buffer.String())
+		}
+	}
+}
+
+func TestUnescapeTag(t *testing.T) {
+	tests := map[string]string{
+		`plain`:       "plain",
+		`a\sb`:        "a b",
+		`a\:b`:        "a;b",
+		`a\\b`:        `a\b`,
+		`a\r\nb`:      "a\r\nb",
+		`unknown\x`:   "unknownx",
+		`trailing\`:   "trailing",
+		`\s\s\:\\\s`:  "  ;\\ ",
+		`double\\\\s`: `double\\s`,
+	}
+
+	for input, want := range tests {
+		if got := UnescapeTag(input); got != want {
+			t.Errorf("UnescapeTag(%q) = %q, want %q", input, got, want)
+		}
+
+		if got := UnescapeTag(EscapeTag(want)); got != want {
+			t.Errorf("UnescapeTag(EscapeTag(%q)) = %q", want, got)
+		}
+	}
+}
+
+func BenchmarkMakeMessage(b *testing.B) {
+	input := `@badge-info=subscriber/8;badges=moderator/1,subscriber/6;color=#0000FF;display-name=Foo\sBar;emotes=;id=b34ccfc7-4977-403a-8a94-33c6bac34fb8;mod=1;room-id=1337;subscriber=1;tmi-sent-ts=1507246572675;turbo=0;user-id=1337;user-type=mod :foo!foo@foo.tmi.twitch.tv PRIVMSG #chan :!vote +alice -bob +carol` + "\r\n"
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := MakeMessage(input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package irc
+
+import (
+	"sync/atomic"
+)
+
+// Pool separates reading from writing across several sessions. The reader
+// session joins channels and receives chat while outgoing messages are
+// spread across the writer sessions. Every session shares a single
+// RateLimit as Twitch counts messages per user rather than per connection. Messages
+// are only sent over writers which are logged in and fall back to the
+// reader when no writer is. Messages queued on a writer which lost its
+// connection are moved to another session.
+type Pool struct {
+	RateLimit *RateLimit
+	Reader    *Session
+	Writers   []*Session
+
+	next uint64
+}
+
+// NewPool dials a reader session and the given number of writer sessions
+// through the transport.
+func NewPool(transport Transport, writers int) (*Pool, error) {
+	pool := &Pool{
+		RateLimit: NewRateLimit(),
+		Writers:   make([]*Session, 0, writers),
+	}
+
+	reader, err := newSession(transport, pool.RateLimit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pool.Reader = reader
+
+	for i := 0; i < writers; i++ {
+		writer, err := newSession(transport, pool.RateLimit)
+
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+
+		writer.handover = pool.WritePriority
+		pool.Writers = append(pool.Writers, writer)
+	}
+
+	return pool, nil
+}
+
+// Close shuts down every session of the Pool.
+func (p *Pool) Close() error {
+	var err error
+
+	if p.Reader != nil {
+		err = p.Reader.Close()
+	}
+
+	for _, writer := range p.Writers {
+		if e := writer.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// Metrics returns the combined counters of every session along with the
+// latency of the reader.
+func (p *Pool) Metrics() Metrics {
+	metrics := p.Reader.Metrics()
+
+	for _, writer := range p.Writers {
+		m := writer.Metrics()
+		metrics.Dropped += m.Dropped
+		metrics.Sent += m.Sent
+
+		for priority, depth := range m.QueueDepth {
+			metrics.QueueDepth[priority] += depth
+		}
+	}
+
+	return metrics
+}
+
+// Session returns the session the next outgoing message is sent over.
+// Writers are used in turn while skipping those which are not logged in,
+// such as while reconnecting.
+func (p *Pool) Session() *Session {
+	n := len(p.Writers)
+
+	for i := 0; i < n; i++ {
+		writer := p.Writers[atomic.AddUint64(&p.next, 1)%uint64(n)]
+
+		if writer.Connected() {
+			return writer
+		}
+	}
+
+	return p.Reader
+}
+
+// SetModerator adjusts the shared rate limit of messages to the given
+// channel to whether the user is a moderator or broadcaster of it.
+func (p *Pool) SetModerator(channel string, moderator bool) {
+	p.RateLimit.SetModerator(channel, moderator)
+}
+
+// Write queues an outgoing message on the next session of the Pool.
+func (p *Pool) Write(message string) {
+	p.Session().Write(message)
+}
+
+// WritePriority queues an outgoing message of the given priority on the
+// next session of the Pool.
+func (p *Pool) WritePriority(message string, priority Priority) {
+	p.Session().WritePriority(message, priority)
+}
@@ -0,0 +1,132 @@
+package irc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kookehs/kneissbot/net/irc"
+	"github.com/kookehs/kneissbot/net/irc/irctest"
+)
+
+// discard is a Handler ignoring every message.
+type discard struct{}
+
+func (discard) In(input []byte) {}
+
+// eventually fails the test unless the condition holds within a second.
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Unable to %v in time", what)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// received returns whether the server received the line over the
+// connection of the given index.
+func received(server *irctest.Server, connection int, line string) bool {
+	connections := server.Connections()
+
+	if connection >= len(connections) {
+		return false
+	}
+
+	for _, message := range connections[connection] {
+		if message.String() == line {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newPool(t *testing.T) (*irctest.Server, *irc.Pool) {
+	server, err := irctest.NewServer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := irc.NewPool(server.Transport(), 1)
+
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		pool.Close()
+		server.Close()
+	})
+
+	go pool.Reader.Listen(discard{})
+	go pool.Writers[0].Listen(discard{})
+	return server, pool
+}
+
+func login(session *irc.Session) {
+	session.Write("PASS oauth:token")
+	session.Write("NICK kneissbot")
+}
+
+func TestPoolSessionLoggedIn(t *testing.T) {
+	_, pool := newPool(t)
+	writer := pool.Writers[0]
+
+	// Writers are only used once the IRC server welcomed them.
+	if pool.Session() != pool.Reader {
+		t.Error("Writer used before logging in")
+	}
+
+	login(writer)
+	eventually(t, "log in", writer.Connected)
+
+	if pool.Session() != writer {
+		t.Error("Writer not used after logging in")
+	}
+
+	// A redialed writer is not used until it logged in again.
+	if err := writer.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "notice the lost connection", func() bool { return !writer.Connected() })
+	time.Sleep(20 * time.Millisecond)
+
+	if writer.Connected() || pool.Session() != pool.Reader {
+		t.Error("Writer used after redialing without logging in")
+	}
+
+	login(writer)
+	eventually(t, "log in again", writer.Connected)
+}
+
+func TestPoolHandover(t *testing.T) {
+	server, pool := newPool(t)
+	writer := pool.Writers[0]
+	login(writer)
+	eventually(t, "log in", writer.Connected)
+
+	if err := writer.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "notice the lost connection", func() bool { return !writer.Connected() })
+
+	// Chat queued on the writer is sent over the reader instead.
+	writer.Write("PRIVMSG #kneissbot :hello world")
+	eventually(t, "hand the message over", func() bool { return received(server, 0, "PRIVMSG #kneissbot :hello world") })
+
+	for i, messages := range server.Connections()[1:] {
+		for _, message := range messages {
+			if message.Command == "PRIVMSG" {
+				t.Errorf("Writer connection %v received %q", i, message.String())
+			}
+		}
+	}
+}
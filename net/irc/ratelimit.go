@@ -0,0 +1,334 @@
+package irc
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Priority determines the order in which queued messages are sent.
+// Messages of a lower value are sent first.
+type Priority int
+
+const (
+	// PriorityProtocol is used for protocol messages such as PONG and CAP.
+	// These are not counted against any rate limit.
+	PriorityProtocol Priority = iota
+	// PriorityJoin is used for JOIN messages, which are counted against
+	// the join rate limit rather than the message rate limit.
+	PriorityJoin
+	// PriorityModeration is used for moderation commands such as /mod and /unmod.
+	PriorityModeration
+	// PriorityChat is used for regular chat messages such as replies.
+	PriorityChat
+
+	priorities
+)
+
+var (
+	// JoinLimit is the number of channels a user may join per JoinWindow.
+	JoinLimit = 20
+	// JoinWindow is the period over which Twitch counts join attempts.
+	JoinWindow = 10 * time.Second
+	// MessageLimit is the number of messages a user may send per
+	// MessageWindow to channels they do not moderate.
+	MessageLimit = 20
+	// ModeratorMessageLimit is the number of messages a user may send per
+	// MessageWindow in total, including to channels they moderate.
+	ModeratorMessageLimit = 100
+	// MessageWindow is the period over which Twitch counts sent messages.
+	MessageWindow = 30 * time.Second
+	// QueueSize is the number of messages each priority lane holds before
+	// new messages are dropped.
+	QueueSize = 64
+)
+
+// PriorityOf returns the default Priority for the given raw message.
+// Chat and JOIN messages are rate limited while protocol messages are not.
+func PriorityOf(message string) Priority {
+	switch {
+	case strings.HasPrefix(message, "PRIVMSG "):
+		return PriorityChat
+	case strings.HasPrefix(message, "JOIN "):
+		return PriorityJoin
+	}
+
+	return PriorityProtocol
+}
+
+// Limiter is a token bucket which refills at a constant rate up to its capacity.
+type Limiter struct {
+	capacity float64
+	last     time.Time
+	mutex    sync.Mutex
+	rate     float64
+	tokens   float64
+}
+
+// NewLimiter creates and initializes a full Limiter which allows limit
+// events per window.
+func NewLimiter(limit int, window time.Duration) *Limiter {
+	l := new(Limiter)
+	l.SetLimit(limit, window)
+	l.tokens = l.capacity
+	return l
+}
+
+// SetLimit changes the number of events allowed per window. Tokens already
+// in the bucket are kept up to the new capacity.
+func (l *Limiter) SetLimit(limit int, window time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.refill(time.Now())
+	l.capacity = float64(limit)
+	l.rate = float64(limit) / window.Seconds()
+
+	if l.tokens > l.capacity {
+		l.tokens = l.capacity
+	}
+}
+
+// Delay returns the time until a token becomes available without taking it.
+func (l *Limiter) Delay() time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.refill(time.Now())
+
+	if l.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Reserve takes a token if one is available and returns zero. Otherwise
+// no token is taken and the time until one becomes available is returned.
+func (l *Limiter) Reserve() time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.refill(time.Now())
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+func (l *Limiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+
+		if l.tokens > l.capacity {
+			l.tokens = l.capacity
+		}
+	}
+
+	l.last = now
+}
+
+// RateLimit limits the chat messages and joins of a user the way Twitch
+// counts them. Every message is counted against Total, which allows
+// ModeratorMessageLimit messages, while messages to channels the user
+// does not moderate are also counted against User, which allows
+// MessageLimit messages. JOIN messages are only counted against Join,
+// which allows JoinLimit joins, so every JOIN should name a single
+// channel. Channels are identified without the leading #.
+type RateLimit struct {
+	Join  *Limiter
+	Total *Limiter
+	User  *Limiter
+
+	moderators map[string]bool
+	mutex      sync.Mutex
+}
+
+// NewRateLimit creates and initializes a RateLimit of a user who does not
+// moderate any channel.
+func NewRateLimit() *RateLimit {
+	return &RateLimit{
+		Join:       NewLimiter(JoinLimit, JoinWindow),
+		Total:      NewLimiter(ModeratorMessageLimit, MessageWindow),
+		User:       NewLimiter(MessageLimit, MessageWindow),
+		moderators: make(map[string]bool),
+	}
+}
+
+// Moderator returns whether the user is a moderator or broadcaster of the
+// given channel.
+func (r *RateLimit) Moderator(channel string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.moderators[channel]
+}
+
+// SetModerator records whether the user is a moderator or broadcaster of
+// the given channel.
+func (r *RateLimit) SetModerator(channel string, moderator bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if moderator {
+		r.moderators[channel] = true
+	} else {
+		delete(r.moderators, channel)
+	}
+}
+
+// Reserve takes a token from every Limiter the message is counted against
+// if all of them have one available and returns zero. Otherwise no token
+// is taken and the time until all of them have one is returned.
+func (r *RateLimit) Reserve(message string) time.Duration {
+	if strings.HasPrefix(message, "JOIN ") {
+		return r.Join.Reserve()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	limiters := []*Limiter{r.Total}
+
+	if !r.moderators[ChannelOf(message)] {
+		limiters = append(limiters, r.User)
+	}
+
+	var wait time.Duration
+
+	for _, limiter := range limiters {
+		if delay := limiter.Delay(); delay > wait {
+			wait = delay
+		}
+	}
+
+	if wait > 0 {
+		return wait
+	}
+
+	for _, limiter := range limiters {
+		limiter.Reserve()
+	}
+
+	return 0
+}
+
+// ChannelOf returns the channel a raw PRIVMSG is sent to without the
+// leading # or an empty string for other messages.
+func ChannelOf(message string) string {
+	if !strings.HasPrefix(message, "PRIVMSG #") {
+		return ""
+	}
+
+	channel := strings.TrimPrefix(message, "PRIVMSG #")
+
+	if i := strings.IndexByte(channel, ' '); i >= 0 {
+		channel = channel[:i]
+	}
+
+	return channel
+}
+
+// Metrics contains counters of the outgoing message queue along with the
+// latency of the connection.
+type Metrics struct {
+	Dropped    uint64
+	Latency    time.Duration
+	QueueDepth [priorities]int
+	Sent       uint64
+}
+
+// Depth returns the total number of queued messages.
+func (m Metrics) Depth() int {
+	depth := 0
+
+	for _, d := range m.QueueDepth {
+		depth += d
+	}
+
+	return depth
+}
+
+// queue holds outgoing messages in one lane per Priority.
+type queue struct {
+	lanes   [priorities][]string
+	metrics Metrics
+	mutex   sync.Mutex
+	signal  chan struct{}
+}
+
+func newQueue() *queue {
+	return &queue{
+		signal: make(chan struct{}, 1),
+	}
+}
+
+// push appends the message to the lane of the given priority. The message
+// is dropped if the lane is full.
+func (q *queue) push(message string, priority Priority) bool {
+	q.mutex.Lock()
+
+	if len(q.lanes[priority]) >= QueueSize {
+		q.metrics.Dropped++
+		q.mutex.Unlock()
+		return false
+	}
+
+	q.lanes[priority] = append(q.lanes[priority], message)
+	q.mutex.Unlock()
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// peek returns the oldest message of the highest priority lane.
+func (q *queue) peek() (string, Priority, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for priority, lane := range q.lanes {
+		if len(lane) > 0 {
+			return lane[0], Priority(priority), true
+		}
+	}
+
+	return "", 0, false
+}
+
+// pop removes the oldest message of the given lane as it was sent.
+func (q *queue) pop(priority Priority) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.shift(priority)
+	q.metrics.Sent++
+}
+
+// take removes the oldest message of the given lane without sending it.
+func (q *queue) take(priority Priority) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.shift(priority)
+}
+
+// shift removes the oldest message of the given lane while the queue is
+// locked.
+func (q *queue) shift(priority Priority) {
+	lane := q.lanes[priority]
+	lane[0] = ""
+	q.lanes[priority] = lane[1:]
+}
+
+// snapshot returns the current metrics of the queue.
+func (q *queue) snapshot() Metrics {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	metrics := q.metrics
+
+	for priority, lane := range q.lanes {
+		metrics.QueueDepth[priority] = len(lane)
+	}
+
+	return metrics
+}
@@ -0,0 +1,84 @@
+package irc
+
+import "testing"
+
+func TestRateLimit(t *testing.T) {
+	r := NewRateLimit()
+	r.SetModerator("moderated", true)
+
+	// Channels the user does not moderate share the lower limit.
+	for i := 0; i < MessageLimit; i++ {
+		if wait := r.Reserve("PRIVMSG #other :hello world"); wait > 0 {
+			t.Fatalf("Message %v to an unmoderated channel waits %v", i, wait)
+		}
+	}
+
+	if wait := r.Reserve("PRIVMSG #another :hello world"); wait <= 0 {
+		t.Error("Unmoderated channels exceed MessageLimit")
+	}
+
+	for i := MessageLimit; i < ModeratorMessageLimit; i++ {
+		if wait := r.Reserve("PRIVMSG #moderated :hello world"); wait > 0 {
+			t.Fatalf("Message %v to a moderated channel waits %v", i, wait)
+		}
+	}
+
+	if wait := r.Reserve("PRIVMSG #moderated :hello world"); wait <= 0 {
+		t.Error("Moderated channels exceed ModeratorMessageLimit")
+	}
+
+	r.SetModerator("moderated", false)
+
+	if r.Moderator("moderated") {
+		t.Error("Moderator status not removed")
+	}
+}
+
+func TestRateLimitJoin(t *testing.T) {
+	r := NewRateLimit()
+
+	for i := 0; i < JoinLimit; i++ {
+		if wait := r.Reserve("JOIN #channel"); wait > 0 {
+			t.Fatalf("JOIN %v waits %v", i, wait)
+		}
+	}
+
+	if wait := r.Reserve("JOIN #another"); wait <= 0 {
+		t.Error("JOINs exceed JoinLimit")
+	}
+
+	// Joins and chat messages are counted separately.
+	if wait := r.Reserve("PRIVMSG #channel :hello world"); wait > 0 {
+		t.Errorf("Message after JoinLimit JOINs waits %v", wait)
+	}
+}
+
+func TestPriorityOf(t *testing.T) {
+	tests := map[string]Priority{
+		"PRIVMSG #kneissbot :hello world": PriorityChat,
+		"JOIN #kneissbot":                 PriorityJoin,
+		"PONG :tmi.twitch.tv":             PriorityProtocol,
+		"CAP REQ :twitch.tv/tags":         PriorityProtocol,
+	}
+
+	for message, want := range tests {
+		if got := PriorityOf(message); got != want {
+			t.Errorf("PriorityOf(%q) = %v, want %v", message, got, want)
+		}
+	}
+}
+
+func TestChannelOf(t *testing.T) {
+	tests := map[string]string{
+		"PRIVMSG #kneissbot :hello world": "kneissbot",
+		"PRIVMSG #kneissbot":              "kneissbot",
+		"PRIVMSG kneissbot :hello":        "",
+		"JOIN #kneissbot":                 "",
+	}
+
+	for message, want := range tests {
+		if got := ChannelOf(message); got != want {
+			t.Errorf("ChannelOf(%q) = %q, want %q", message, got, want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package irc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+)
+
+// LineReader reads CRLF terminated lines from an underlying connection.
+// Partial lines are buffered until the rest of the line arrives in a
+// later read, so a line split across websocket frames is returned whole.
+type LineReader struct {
+	reader *bufio.Reader
+}
+
+// NewLineReader creates and initializes a LineReader reading from r.
+func NewLineReader(r io.Reader) *LineReader {
+	return &LineReader{
+		reader: bufio.NewReaderSize(r, MaxMessageSize+MaxTagsSize),
+	}
+}
+
+// ReadLine returns the next non-empty line without its line ending.
+// Lines longer than MaxMessageSize and MaxTagsSize combined are discarded. The returned slice is
+// owned by the caller.
+func (lr *LineReader) ReadLine() ([]byte, error) {
+	for {
+		line, err := lr.reader.ReadSlice('\n')
+
+		if err == bufio.ErrBufferFull {
+			log.Println("[IRC]: Discarding line exceeding maximum size")
+
+			if err = lr.discard(); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		line = bytes.TrimRight(line, "\r\n")
+
+		if len(line) == 0 {
+			continue
+		}
+
+		out := make([]byte, len(line))
+		copy(out, line)
+		return out, nil
+	}
+}
+
+// discard skips the remainder of the current line.
+func (lr *LineReader) discard() error {
+	for {
+		_, err := lr.reader.ReadSlice('\n')
+
+		if err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+}
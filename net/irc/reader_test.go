@@ -0,0 +1,55 @@
+package irc
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestLineReader(t *testing.T) {
+	long := strings.Repeat("a", MaxMessageSize+MaxTagsSize+1)
+	input := "PING :tmi.twitch.tv\r\n" +
+		"\r\n\n" +
+		"PRIVMSG #kneissbot :" + long + "\r\n" +
+		"PRIVMSG #kneissbot :hello world\n" +
+		"PONG :tmi.twitch.tv\r\n"
+
+	// Reading a byte at a time splits every line across reads.
+	reader := NewLineReader(iotest.OneByteReader(strings.NewReader(input)))
+	want := []string{"PING :tmi.twitch.tv", "PRIVMSG #kneissbot :hello world", "PONG :tmi.twitch.tv"}
+
+	for i, line := range want {
+		got, err := reader.ReadLine()
+
+		if err != nil {
+			t.Fatalf("Line %v: %v", i, err)
+		}
+
+		if string(got) != line {
+			t.Errorf("Line %v = %q, want %q", i, got, line)
+		}
+	}
+
+	if _, err := reader.ReadLine(); err != io.EOF {
+		t.Errorf("ReadLine after the last line returned %v, want %v", err, io.EOF)
+	}
+}
+
+func TestLineReaderOwnership(t *testing.T) {
+	reader := NewLineReader(strings.NewReader("first\r\nsecond\r\n"))
+	first, err := reader.ReadLine()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := reader.ReadLine(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Returned lines are not overwritten by later reads.
+	if string(first) != "first" {
+		t.Errorf("First line changed to %q", first)
+	}
+}
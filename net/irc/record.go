@@ -0,0 +1,138 @@
+package irc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kookehs/kneissbot/time/clock"
+)
+
+// ErrMalformedRecord is returned when a recorded line cannot be read.
+var ErrMalformedRecord = errors.New("irc: malformed record")
+
+// Record is an inbound line along with the time it was received.
+type Record struct {
+	Line []byte
+	Time time.Time
+}
+
+// Recorder writes inbound lines along with the time they were received so
+// that a session can be replayed later. Each record is written on its own
+// line as the receive time in nanoseconds since the Unix epoch followed by
+// a space and the raw line.
+type Recorder struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// NewRecorder creates and initializes a Recorder writing to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{
+		writer: w,
+	}
+}
+
+// Record writes the line with the given receive time.
+func (r *Recorder) Record(t time.Time, line []byte) error {
+	var buffer bytes.Buffer
+	buffer.WriteString(strconv.FormatInt(t.UnixNano(), 10))
+	buffer.WriteByte(' ')
+	buffer.Write(line)
+	buffer.WriteByte('\n')
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, err := r.writer.Write(buffer.Bytes())
+	return err
+}
+
+// RecordReader reads records written by a Recorder.
+type RecordReader struct {
+	reader *bufio.Reader
+}
+
+// NewRecordReader creates and initializes a RecordReader reading from r.
+func NewRecordReader(r io.Reader) *RecordReader {
+	return &RecordReader{
+		reader: bufio.NewReaderSize(r, MaxMessageSize+MaxTagsSize),
+	}
+}
+
+// Read returns the next record. io.EOF is returned after the last record.
+func (rr *RecordReader) Read() (Record, error) {
+	line, err := rr.reader.ReadBytes('\n')
+
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+
+	if err != nil {
+		return Record{}, err
+	}
+
+	line = bytes.TrimRight(line, "\r\n")
+	space := bytes.IndexByte(line, ' ')
+
+	if space == -1 {
+		return Record{}, ErrMalformedRecord
+	}
+
+	ns, err := strconv.ParseInt(string(line[:space]), 10, 64)
+
+	if err != nil {
+		return Record{}, ErrMalformedRecord
+	}
+
+	return Record{
+		Line: line[space+1:],
+		Time: time.Unix(0, ns),
+	}, nil
+}
+
+// ReplayHandler is implemented by handlers which schedule timers on the
+// clock given to Replay. Replaying is called once the clock has been set
+// to the receive time of the first record.
+type ReplayHandler interface {
+	Replaying()
+}
+
+// Replay sends recorded lines to the handler without a network connection.
+// The fake clock is moved to the receive time of each record before its
+// line is handled, so timers fire at the same points as when recording.
+// A speed of 1 replays in real time, a greater speed replays accelerated
+// and a speed of 0 replays instantly. Lines are handled one at a time in
+// the calling goroutine.
+func Replay(r io.Reader, handler Handler, fake *clock.Fake, speed float64) error {
+	reader := NewRecordReader(r)
+	var previous time.Time
+
+	for {
+		record, err := reader.Read()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if previous.IsZero() {
+			fake.Set(record.Time)
+
+			if replayer, ok := handler.(ReplayHandler); ok {
+				replayer.Replaying()
+			}
+		} else if speed > 0 {
+			time.Sleep(time.Duration(float64(record.Time.Sub(previous)) / speed))
+		}
+
+		previous = record.Time
+		fake.Set(record.Time)
+		handler.In(record.Line)
+	}
+}
@@ -0,0 +1,105 @@
+package irc
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kookehs/kneissbot/time/clock"
+)
+
+// replayer records the lines it handles along with the time of the clock.
+// Once replaying, it schedules a timer for every delay.
+type replayer struct {
+	clock  *clock.Fake
+	delays []time.Duration
+	events []string
+}
+
+func (r *replayer) In(input []byte) {
+	r.events = append(r.events, r.clock.Now().UTC().Format("15:04:05")+" "+string(input))
+}
+
+func (r *replayer) Replaying() {
+	r.In([]byte("replaying"))
+
+	for _, delay := range r.delays {
+		r.clock.AfterFunc(delay, func() { r.In([]byte("timer")) })
+	}
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	start := time.Unix(1700000000, 123)
+	records := []Record{
+		{Line: []byte("@tmi-sent-ts=1 :alice!alice@alice.tmi.twitch.tv PRIVMSG #kneissbot :hello world"), Time: start},
+		{Line: []byte("PING :tmi.twitch.tv"), Time: start.Add(time.Second)},
+		{Line: []byte(":tmi.twitch.tv CLEARCHAT #kneissbot"), Time: start.Add(time.Minute)},
+	}
+
+	var buffer bytes.Buffer
+	recorder := NewRecorder(&buffer)
+
+	for _, record := range records {
+		if err := recorder.Record(record.Time, record.Line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader := NewRecordReader(&buffer)
+
+	for i, want := range records {
+		got, err := reader.Read()
+
+		if err != nil {
+			t.Fatalf("Record %v: %v", i, err)
+		}
+
+		if !bytes.Equal(got.Line, want.Line) || !got.Time.Equal(want.Time) {
+			t.Errorf("Record %v = %q at %v, want %q at %v", i, got.Line, got.Time, want.Line, want.Time)
+		}
+	}
+
+	if _, err := reader.Read(); err != io.EOF {
+		t.Errorf("Read after the last record returned %v, want %v", err, io.EOF)
+	}
+}
+
+func TestRecordMalformed(t *testing.T) {
+	for _, input := range []string{"PING :tmi.twitch.tv\n", "soon PING :tmi.twitch.tv\n"} {
+		if _, err := NewRecordReader(strings.NewReader(input)).Read(); err != ErrMalformedRecord {
+			t.Errorf("Read(%q) returned %v, want %v", input, err, ErrMalformedRecord)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var buffer bytes.Buffer
+	recorder := NewRecorder(&buffer)
+	recorder.Record(start, []byte("first"))
+	recorder.Record(start.Add(2*time.Second), []byte("second"))
+	recorder.Record(start.Add(5*time.Second), []byte("third"))
+	fake := clock.NewFake(time.Time{})
+	handler := &replayer{clock: fake, delays: []time.Duration{3 * time.Second, time.Second}}
+
+	if err := Replay(&buffer, handler, fake, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Timers fire between the records around their deadlines.
+	want := []string{
+		"12:00:00 replaying",
+		"12:00:00 first",
+		"12:00:01 timer",
+		"12:00:02 second",
+		"12:00:03 timer",
+		"12:00:05 third",
+	}
+
+	if !reflect.DeepEqual(handler.events, want) {
+		t.Errorf("Replayed\n%q\nwant\n%q", handler.events, want)
+	}
+}
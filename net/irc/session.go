@@ -2,10 +2,14 @@ package irc
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"log"
+	"math/rand"
+	"sync"
+	"time"
 
-	"golang.org/x/net/websocket"
+	"github.com/kookehs/kneissbot/time/clock"
 )
 
 const (
@@ -21,76 +25,331 @@ const (
 	RPL_ENDOFMOTD  = "376"
 )
 
-// TODO: Add rate limiting settings.
 var (
 	// MaxMessageSize is a fixed message length in bytes as specified by RFC1459
 	MaxMessageSize = 512
+	// MaxTagsSize is the additional length in bytes reserved for IRCv3 message tags.
+	MaxTagsSize = 8191
+
+	// ReconnectMinDelay is the delay before the first reconnect attempt.
+	ReconnectMinDelay = time.Second
+	// ReconnectMaxDelay is the upper bound of the delay between reconnect attempts.
+	ReconnectMaxDelay = 2 * time.Minute
 )
 
+// ErrSessionClosed is returned when operating on a closed Session.
+var ErrSessionClosed = errors.New("irc: session closed")
+
 // Handler is an interface which should handle incoming messages.
 type Handler interface {
 	In(input []byte)
 }
 
+// ReconnectHandler is implemented by handlers which need to restore state,
+// such as authentication and joined channels, after the Session has
+// reconnected to the IRC server.
+type ReconnectHandler interface {
+	Reconnected()
+}
+
 // Session contains variables required to interact with the IRC server
-// over a websocket connection.
+// over a connection established by its Transport. The Session is
+// connected once the IRC server welcomed the user after logging in.
+// Keepalives are timed by the Clock, which must be set before listening.
 type Session struct {
-	Websocket *websocket.Conn
+	Clock     clock.Clock
+	Conn      io.ReadWriteCloser
+	RateLimit *RateLimit
+	Recorder  *Recorder
+	Transport Transport
+
+	closed    bool
+	connected bool
+	done      chan struct{}
+	handover  func(message string, priority Priority)
+	lastRead  int64
+	latency   int64
+	mutex     sync.RWMutex
+	queue     *queue
 }
 
-// NewSession creates and initializes a Session connected to the given URL.
-func NewSession(origin, url string) (*Session, error) {
-	ws, err := websocket.Dial(url, "", origin)
+// NewSession creates and initializes a Session connected through the given Transport.
+func NewSession(transport Transport) (*Session, error) {
+	return newSession(transport, NewRateLimit())
+}
+
+// newSession creates and initializes a Session using the given RateLimit.
+func newSession(transport Transport, rateLimit *RateLimit) (*Session, error) {
+	conn, err := transport.Dial()
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &Session{
-		Websocket: ws,
-	}, nil
+	session := &Session{
+		Clock:     clock.Real{},
+		Conn:      conn,
+		RateLimit: rateLimit,
+		Transport: transport,
+		done:      make(chan struct{}),
+		queue:     newQueue(),
+	}
+
+	go session.send()
+	return session, nil
 }
 
-// Close forcefully shuts down the underlying websocket connection.
+// Backoff returns the delay before the given reconnect attempt. The delay
+// doubles with every attempt up to ReconnectMaxDelay and half of it is
+// randomized to avoid reconnecting in lockstep with other clients.
+func Backoff(attempt int) time.Duration {
+	delay := ReconnectMaxDelay
+
+	if attempt < 32 {
+		if d := ReconnectMinDelay << uint(attempt); d > 0 && d < ReconnectMaxDelay {
+			delay = d
+		}
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Close forcefully shuts down the underlying connection.
+// A closed Session does not reconnect and queued messages are discarded.
 func (s *Session) Close() error {
-	return s.Websocket.Close()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrSessionClosed
+	}
+
+	s.closed = true
+	close(s.done)
+	return s.Conn.Close()
+}
+
+// Connected returns whether the Session is logged into the IRC server
+// over a connection which has not been lost.
+func (s *Session) Connected() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.connected && !s.closed
+}
+
+// Closed returns whether the Session has been closed.
+func (s *Session) Closed() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.closed
 }
 
 // Listen sends any incoming message from the IRC server to the handler.
 // Listen should be run as a goroutine. Handler functions are ran as goroutines.
+// When the connection is lost the Session redials and, if the handler is a
+// ReconnectHandler, notifies it once the new connection is established.
+// While listening the Session sends its own PING messages to detect a dead
+// connection. Replies to them are not sent to the handler.
 func (s *Session) Listen(handler Handler) {
+	s.touch()
+	s.keepalive()
+
 	for {
-		buffer := make([]byte, MaxMessageSize)
-		_, err := s.Websocket.Read(buffer)
+		s.mutex.RLock()
+		reader := NewLineReader(s.Conn)
+		s.mutex.RUnlock()
+
+		for {
+			message, err := reader.ReadLine()
+
+			if err != nil {
+				log.Println(err)
+				break
+			}
+
+			s.touch()
+			s.welcome(message)
+			log.Println("[IRC]: " + string(message))
+
+			if s.Recorder != nil {
+				if err := s.Recorder.Record(s.Clock.Now(), message); err != nil {
+					log.Println(err)
+				}
+			}
+
+			if s.pong(message) {
+				continue
+			}
 
-		if err != nil {
+			go handler.In(message)
+		}
+
+		log.Println("[IRC]: Lost connection to IRC server")
+		s.mutex.Lock()
+		s.connected = false
+		s.mutex.Unlock()
+
+		if err := s.Redial(); err != nil {
 			log.Println(err)
+			return
+		}
+
+		if reconnector, ok := handler.(ReconnectHandler); ok {
+			go reconnector.Reconnected()
+		}
+	}
+}
+
+// Reconnect closes the current connection so that Listen redials the IRC
+// server. Reconnect is used when the server asks clients to reconnect.
+func (s *Session) Reconnect() error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.Conn.Close()
+}
 
-			if err == io.EOF {
-				// TODO: Reconnect to IRC.
-				panic("[IRC]: Lost connection to IRC server")
+// Redial establishes a new connection to the IRC server, retrying with
+// exponential backoff until it succeeds or the Session is closed. The
+// Session is connected again once the user logged in.
+func (s *Session) Redial() error {
+	for attempt := 0; ; attempt++ {
+		if s.Closed() {
+			return ErrSessionClosed
+		}
+
+		conn, err := s.Transport.Dial()
+
+		if err == nil {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+
+			if s.closed {
+				conn.Close()
+				return ErrSessionClosed
 			}
 
+			s.Conn = conn
+			s.touch()
+			log.Println("[IRC]: Reconnected to IRC server")
+			return nil
+		}
+
+		delay := Backoff(attempt)
+		log.Printf("[IRC]: Reconnect attempt %v failed, retrying in %v: %v", attempt+1, delay, err)
+		time.Sleep(delay)
+	}
+}
+
+// Metrics returns the current counters of the outgoing message queue and
+// the latency of the connection.
+func (s *Session) Metrics() Metrics {
+	metrics := s.queue.snapshot()
+	metrics.Latency = s.Latency()
+	return metrics
+}
+
+// SetModerator adjusts the rate limit of messages to the given channel to
+// whether the user is a moderator or broadcaster of it.
+func (s *Session) SetModerator(channel string, moderator bool) {
+	s.RateLimit.SetModerator(channel, moderator)
+}
+
+// Write queues an outgoing message to the IRC server with the Priority
+// returned by PriorityOf.
+func (s *Session) Write(message string) {
+	s.WritePriority(message, PriorityOf(message))
+}
+
+// WritePriority queues an outgoing message to the IRC server in the lane
+// of the given priority. The message is dropped if the lane is full.
+func (s *Session) WritePriority(message string, priority Priority) {
+	if !s.queue.push(message, priority) {
+		log.Println("[IRC]: Dropped outgoing message: " + message)
+	}
+}
+
+// send writes queued messages to the IRC server in order of priority while
+// keeping rate limited messages within the RateLimit.
+func (s *Session) send() {
+	for {
+		message, priority, ok := s.queue.peek()
+
+		if !ok {
+			select {
+			case <-s.queue.signal:
+			case <-s.done:
+				return
+			}
+
+			continue
+		}
+
+		// Rate limited messages of a writer which is not logged in are
+		// sent by another session of the Pool instead.
+		if priority != PriorityProtocol && s.handover != nil && !s.Connected() {
+			s.queue.take(priority)
+			s.handover(message, priority)
 			continue
 		}
 
-		messages := bytes.Split(bytes.TrimRight(buffer, "\x00"), []byte{'\n'})
+		if priority != PriorityProtocol {
+			if wait := s.RateLimit.Reserve(message); wait > 0 {
+				// Wake up early if a message of higher priority is queued.
+				timer := time.NewTimer(wait)
+
+				select {
+				case <-timer.C:
+				case <-s.queue.signal:
+					timer.Stop()
+				case <-s.done:
+					timer.Stop()
+					return
+				}
+
+				continue
+			}
+		}
+
+		s.queue.pop(priority)
 
-		for _, message := range messages {
-			if len(message) > 0 {
-				log.Println("[IRC]: " + string(message))
-				go handler.In(message)
+		if err := s.write(message); err != nil {
+			log.Println(err)
+			s.mutex.Lock()
+			s.connected = false
+			s.mutex.Unlock()
+
+			if priority != PriorityProtocol && s.handover != nil {
+				s.handover(message, priority)
 			}
 		}
 	}
 }
 
-// Write sends an outgoing message to the IRC server.
-func (s *Session) Write(message string) {
-	out := []byte(message)
-	log.Println("[IRC]: " + message)
+// welcome marks the Session connected if the line is the reply of the IRC
+// server to logging in.
+func (s *Session) welcome(line []byte) {
+	if s.Connected() || !bytes.Contains(line, []byte(RPL_WELCOME)) && !bytes.Contains(line, []byte("GLOBALUSERSTATE")) {
+		return
+	}
 
-	if _, err := s.Websocket.Write(out); err != nil {
-		log.Println(err)
+	message, err := MakeMessage(string(line))
+
+	if err != nil || (message.Command != RPL_WELCOME && message.Command != "GLOBALUSERSTATE") {
+		return
 	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.connected = true
+}
+
+// write sends an outgoing message terminated by CRLF over the current connection.
+func (s *Session) write(message string) error {
+	out := []byte(message + "\r\n")
+	log.Println("[IRC]: " + message)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, err := s.Conn.Write(out)
+	return err
 }
@@ -0,0 +1,137 @@
+package irc_test
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kookehs/kneissbot/net/irc"
+	"github.com/kookehs/kneissbot/net/irc/irctest"
+)
+
+// reconnector signals every time its Session reconnected.
+type reconnector chan struct{}
+
+func (r reconnector) In(input []byte) {}
+
+func (r reconnector) Reconnected() {
+	r <- struct{}{}
+}
+
+// flaky fails to dial a number of times before dialing its Transport.
+type flaky struct {
+	irc.Transport
+	failures int
+	mutex    sync.Mutex
+}
+
+func (f *flaky) Dial() (io.ReadWriteCloser, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.failures > 0 {
+		f.failures--
+		return nil, errors.New("connection refused")
+	}
+
+	return f.Transport.Dial()
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 40; attempt++ {
+		delay := irc.ReconnectMaxDelay
+
+		if attempt < 7 {
+			delay = irc.ReconnectMinDelay << uint(attempt)
+		}
+
+		if got := irc.Backoff(attempt); got < delay/2 || got > delay {
+			t.Errorf("Backoff(%v) = %v, want between %v and %v", attempt, got, delay/2, delay)
+		}
+	}
+}
+
+func TestSessionRedial(t *testing.T) {
+	delay := irc.ReconnectMinDelay
+	irc.ReconnectMinDelay = time.Millisecond
+	defer func() { irc.ReconnectMinDelay = delay }()
+	server, err := irctest.NewServer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+	transport := &flaky{Transport: server.Transport()}
+	session, err := irc.NewSession(transport)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer session.Close()
+	handler := make(reconnector, 1)
+	go session.Listen(handler)
+	login(session)
+	eventually(t, "log in", session.Connected)
+
+	// Failed dials are retried until the server is reachable again.
+	transport.mutex.Lock()
+	transport.failures = 3
+	transport.mutex.Unlock()
+	server.Disconnect()
+
+	select {
+	case <-handler:
+	case <-time.After(time.Second):
+		t.Fatal("Session not reconnected")
+	}
+
+	if session.Connected() {
+		t.Error("Session connected before logging in again")
+	}
+
+	login(session)
+	eventually(t, "log in again", session.Connected)
+
+	if n := len(server.Connections()); n != 2 {
+		t.Errorf("Server accepted %v connections, want 2", n)
+	}
+}
+
+func TestSessionClosed(t *testing.T) {
+	server, err := irctest.NewServer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+	session, err := irc.NewSession(server.Transport())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		session.Listen(make(reconnector, 1))
+		close(done)
+	}()
+
+	// A closed Session stops listening rather than redialing.
+	session.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Closed session still listening")
+	}
+
+	if err := session.Redial(); err != irc.ErrSessionClosed {
+		t.Errorf("Redial returned %v, want %v", err, irc.ErrSessionClosed)
+	}
+}
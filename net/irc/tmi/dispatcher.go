@@ -0,0 +1,122 @@
+package tmi
+
+import (
+	"log"
+	"sync"
+
+	"github.com/kookehs/kneissbot/net/irc"
+)
+
+// Dispatcher decodes incoming messages and calls the handlers subscribed
+// to their command. Handlers subscribed with OnCommand receive the raw
+// message and are used for commands without an Event type such as PING
+// and numeric replies.
+type Dispatcher struct {
+	commands map[string][]func(irc.Message)
+	events   map[string][]func(Event)
+	mutex    sync.RWMutex
+}
+
+// NewDispatcher creates and initializes a Dispatcher without handlers.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		commands: make(map[string][]func(irc.Message)),
+		events:   make(map[string][]func(Event)),
+	}
+}
+
+// Dispatch calls every handler subscribed to the command of the message.
+// The message is only decoded when a typed handler is subscribed.
+func (d *Dispatcher) Dispatch(message irc.Message) {
+	d.mutex.RLock()
+	commands := d.commands[message.Command]
+	events := d.events[message.Command]
+	d.mutex.RUnlock()
+
+	for _, handler := range commands {
+		handler(message)
+	}
+
+	if len(events) == 0 {
+		return
+	}
+
+	event, err := Parse(message)
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, handler := range events {
+		handler(event)
+	}
+}
+
+// OnCommand subscribes a handler to the raw messages of the given command.
+func (d *Dispatcher) OnCommand(command string, handler func(irc.Message)) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.commands[command] = append(d.commands[command], handler)
+}
+
+// OnClearChat subscribes a handler to CLEARCHAT events.
+func (d *Dispatcher) OnClearChat(handler func(*ClearChat)) {
+	d.on("CLEARCHAT", func(e Event) { handler(e.(*ClearChat)) })
+}
+
+// OnClearMsg subscribes a handler to CLEARMSG events.
+func (d *Dispatcher) OnClearMsg(handler func(*ClearMsg)) {
+	d.on("CLEARMSG", func(e Event) { handler(e.(*ClearMsg)) })
+}
+
+// OnHostTarget subscribes a handler to HOSTTARGET events.
+func (d *Dispatcher) OnHostTarget(handler func(*HostTarget)) {
+	d.on("HOSTTARGET", func(e Event) { handler(e.(*HostTarget)) })
+}
+
+// OnJoin subscribes a handler to JOIN events.
+func (d *Dispatcher) OnJoin(handler func(*Join)) {
+	d.on("JOIN", func(e Event) { handler(e.(*Join)) })
+}
+
+// OnNotice subscribes a handler to NOTICE events.
+func (d *Dispatcher) OnNotice(handler func(*Notice)) {
+	d.on("NOTICE", func(e Event) { handler(e.(*Notice)) })
+}
+
+// OnPart subscribes a handler to PART events.
+func (d *Dispatcher) OnPart(handler func(*Part)) {
+	d.on("PART", func(e Event) { handler(e.(*Part)) })
+}
+
+// OnPrivMsg subscribes a handler to PRIVMSG events.
+func (d *Dispatcher) OnPrivMsg(handler func(*PrivMsg)) {
+	d.on("PRIVMSG", func(e Event) { handler(e.(*PrivMsg)) })
+}
+
+// OnReconnect subscribes a handler to RECONNECT events.
+func (d *Dispatcher) OnReconnect(handler func(*Reconnect)) {
+	d.on("RECONNECT", func(e Event) { handler(e.(*Reconnect)) })
+}
+
+// OnRoomState subscribes a handler to ROOMSTATE events.
+func (d *Dispatcher) OnRoomState(handler func(*RoomState)) {
+	d.on("ROOMSTATE", func(e Event) { handler(e.(*RoomState)) })
+}
+
+// OnUserNotice subscribes a handler to USERNOTICE events.
+func (d *Dispatcher) OnUserNotice(handler func(*UserNotice)) {
+	d.on("USERNOTICE", func(e Event) { handler(e.(*UserNotice)) })
+}
+
+// OnUserState subscribes a handler to USERSTATE events.
+func (d *Dispatcher) OnUserState(handler func(*UserState)) {
+	d.on("USERSTATE", func(e Event) { handler(e.(*UserState)) })
+}
+
+func (d *Dispatcher) on(command string, handler func(Event)) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.events[command] = append(d.events[command], handler)
+}
@@ -0,0 +1,329 @@
+package tmi
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kookehs/kneissbot/net/irc"
+)
+
+// USERNOTICE msg-id values
+const (
+	AnonSubGiftNotice    = "anonsubgift"
+	RaidNotice           = "raid"
+	ResubNotice          = "resub"
+	SubGiftNotice        = "subgift"
+	SubMysteryGiftNotice = "submysterygift"
+	SubNotice            = "sub"
+)
+
+// ErrUnsupportedCommand is returned by Parse for commands without an Event type.
+var ErrUnsupportedCommand = errors.New("tmi: unsupported command")
+
+// Event is a Twitch IRC message decoded into typed fields.
+type Event interface {
+	Raw() irc.Message
+}
+
+// Base contains fields shared by every Event.
+type Base struct {
+	Channel string
+	Message irc.Message
+	SentAt  time.Time
+}
+
+// Raw returns the message the Event was decoded from.
+func (b *Base) Raw() irc.Message {
+	return b.Message
+}
+
+// User contains the tags describing the chatter who caused an Event.
+type User struct {
+	Badges      map[string]string
+	Color       string
+	DisplayName string
+	Login       string
+	Moderator   bool
+	Subscriber  bool
+	UserID      string
+}
+
+// Broadcaster returns whether the user owns the channel.
+func (u *User) Broadcaster() bool {
+	_, ok := u.Badges["broadcaster"]
+	return ok
+}
+
+// PrivMsg is a chat message sent to a channel.
+type PrivMsg struct {
+	Base
+	User
+	Action        bool
+	Bits          int
+	ID            string
+	ReplyParentID string
+	Text          string
+}
+
+// ClearChat is sent when a user is banned or timed out, or when the chat
+// of a channel is cleared. Login is empty when the whole chat is cleared.
+type ClearChat struct {
+	Base
+	BanDuration  time.Duration
+	Login        string
+	TargetUserID string
+}
+
+// Permanent returns whether the user was banned rather than timed out.
+func (cc *ClearChat) Permanent() bool {
+	return len(cc.Login) > 0 && cc.BanDuration == 0
+}
+
+// ClearMsg is sent when a single message is deleted.
+type ClearMsg struct {
+	Base
+	Login       string
+	TargetMsgID string
+	Text        string
+}
+
+// UserNotice is sent for subscriptions, gifts, raids and similar events.
+// MsgID is one of the USERNOTICE msg-id values.
+type UserNotice struct {
+	Base
+	User
+	CumulativeMonths int
+	GiftCount        int
+	ID               string
+	MsgID            string
+	RecipientID      string
+	RecipientLogin   string
+	StreakMonths     int
+	SubPlan          string
+	SystemMsg        string
+	Text             string
+	Viewers          int
+}
+
+// RoomState contains the chat settings of a channel. Twitch sends every
+// setting when joining and only the changed setting afterwards, so a
+// field is only meaningful when its tag is present in the message.
+// FollowersOnly is negative when followers-only mode is disabled.
+type RoomState struct {
+	Base
+	EmoteOnly     bool
+	FollowersOnly time.Duration
+	R9K           bool
+	RoomID        string
+	Slow          time.Duration
+	SubsOnly      bool
+}
+
+// UserState contains the state of the bot's user in a channel.
+type UserState struct {
+	Base
+	User
+	EmoteSets []string
+}
+
+// Notice is a message from the server, identified by MsgID.
+type Notice struct {
+	Base
+	MsgID string
+	Text  string
+}
+
+// HostTarget is sent when a channel starts or stops hosting another channel.
+// Target is empty when hosting stops.
+type HostTarget struct {
+	Base
+	Target  string
+	Viewers int
+}
+
+// Join is sent when a user joins a channel.
+type Join struct {
+	Base
+	Login string
+}
+
+// Part is sent when a user leaves a channel.
+type Part struct {
+	Base
+	Login string
+}
+
+// Reconnect is sent when the server is about to terminate the connection.
+type Reconnect struct {
+	Base
+}
+
+// Parse decodes a message into its Event type.
+func Parse(message irc.Message) (Event, error) {
+	base := makeBase(message)
+
+	switch message.Command {
+	case "PRIVMSG":
+		text, action := actionText(param(message, 1))
+		return &PrivMsg{
+			Base:          base,
+			User:          makeUser(message),
+			Action:        action,
+			Bits:          atoi(message.Tags["bits"]),
+			ID:            message.Tags["id"],
+			ReplyParentID: message.Tags["reply-parent-msg-id"],
+			Text:          text,
+		}, nil
+	case "CLEARCHAT":
+		return &ClearChat{
+			Base:         base,
+			BanDuration:  time.Duration(atoi(message.Tags["ban-duration"])) * time.Second,
+			Login:        param(message, 1),
+			TargetUserID: message.Tags["target-user-id"],
+		}, nil
+	case "CLEARMSG":
+		return &ClearMsg{
+			Base:        base,
+			Login:       message.Tags["login"],
+			TargetMsgID: message.Tags["target-msg-id"],
+			Text:        param(message, 1),
+		}, nil
+	case "USERNOTICE":
+		return &UserNotice{
+			Base:             base,
+			User:             makeUser(message),
+			CumulativeMonths: atoi(message.Tags["msg-param-cumulative-months"]),
+			GiftCount:        atoi(message.Tags["msg-param-mass-gift-count"]),
+			ID:               message.Tags["id"],
+			MsgID:            message.Tags["msg-id"],
+			RecipientID:      message.Tags["msg-param-recipient-id"],
+			RecipientLogin:   message.Tags["msg-param-recipient-user-name"],
+			StreakMonths:     atoi(message.Tags["msg-param-streak-months"]),
+			SubPlan:          message.Tags["msg-param-sub-plan"],
+			SystemMsg:        message.Tags["system-msg"],
+			Text:             param(message, 1),
+			Viewers:          atoi(message.Tags["msg-param-viewerCount"]),
+		}, nil
+	case "ROOMSTATE":
+		return &RoomState{
+			Base:          base,
+			EmoteOnly:     message.Tags["emote-only"] == "1",
+			FollowersOnly: time.Duration(atoi(message.Tags["followers-only"])) * time.Minute,
+			R9K:           message.Tags["r9k"] == "1",
+			RoomID:        message.Tags["room-id"],
+			Slow:          time.Duration(atoi(message.Tags["slow"])) * time.Second,
+			SubsOnly:      message.Tags["subs-only"] == "1",
+		}, nil
+	case "USERSTATE":
+		var sets []string
+
+		if len(message.Tags["emote-sets"]) > 0 {
+			sets = strings.Split(message.Tags["emote-sets"], ",")
+		}
+
+		return &UserState{
+			Base:      base,
+			User:      makeUser(message),
+			EmoteSets: sets,
+		}, nil
+	case "NOTICE":
+		return &Notice{
+			Base:  base,
+			MsgID: message.Tags["msg-id"],
+			Text:  param(message, 1),
+		}, nil
+	case "HOSTTARGET":
+		fields := strings.Fields(param(message, 1))
+		event := &HostTarget{Base: base}
+
+		if len(fields) > 0 && strings.Compare(fields[0], "-") != 0 {
+			event.Target = fields[0]
+		}
+
+		if len(fields) > 1 {
+			event.Viewers = atoi(fields[1])
+		}
+
+		return event, nil
+	case "JOIN":
+		return &Join{Base: base, Login: message.Prefix.Name}, nil
+	case "PART":
+		return &Part{Base: base, Login: message.Prefix.Name}, nil
+	case "RECONNECT":
+		return &Reconnect{Base: base}, nil
+	}
+
+	return nil, ErrUnsupportedCommand
+}
+
+// actionText strips the CTCP ACTION framing used by /me.
+func actionText(text string) (string, bool) {
+	const prefix = "\x01ACTION "
+
+	if strings.HasPrefix(text, prefix) && strings.HasSuffix(text, "\x01") {
+		return text[len(prefix) : len(text)-1], true
+	}
+
+	return text, false
+}
+
+// atoi returns the integer value of s, or zero if it is not a number.
+func atoi(s string) int {
+	n, err := strconv.Atoi(s)
+
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
+
+// makeBase returns the fields shared by every Event.
+func makeBase(message irc.Message) Base {
+	base := Base{
+		Channel: strings.TrimPrefix(param(message, 0), "#"),
+		Message: message,
+	}
+
+	if ts, err := strconv.ParseInt(message.Tags["tmi-sent-ts"], 10, 64); err == nil {
+		base.SentAt = time.Unix(0, ts*int64(time.Millisecond))
+	}
+
+	return base
+}
+
+// makeUser returns the user described by the tags of the message.
+func makeUser(message irc.Message) User {
+	user := User{
+		Badges:      make(map[string]string),
+		Color:       message.Tags["color"],
+		DisplayName: message.Tags["display-name"],
+		Login:       message.Tags["login"],
+		Moderator:   message.Tags["mod"] == "1",
+		Subscriber:  message.Tags["subscriber"] == "1",
+		UserID:      message.Tags["user-id"],
+	}
+
+	if len(user.Login) == 0 {
+		user.Login = message.Prefix.Name
+	}
+
+	for _, badge := range strings.Split(message.Tags["badges"], ",") {
+		if pair := strings.SplitN(badge, "/", 2); len(pair) == 2 {
+			user.Badges[pair[0]] = pair[1]
+		}
+	}
+
+	return user
+}
+
+// param returns the parameter at index i or an empty string.
+func param(message irc.Message, i int) string {
+	if i < len(message.Params) {
+		return message.Params[i]
+	}
+
+	return ""
+}
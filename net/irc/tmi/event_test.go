@@ -0,0 +1,226 @@
+package tmi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kookehs/kneissbot/net/irc"
+)
+
+func TestParse(t *testing.T) {
+	sentAt := time.Unix(0, 1700000000123*int64(time.Millisecond))
+	tests := []struct {
+		name  string
+		input string
+		want  Event
+	}{
+		{
+			name:  "chat message",
+			input: `@badges=broadcaster/1,subscriber/12;bits=100;color=#FF0000;display-name=Alice;id=abc;mod=1;reply-parent-msg-id=def;subscriber=1;tmi-sent-ts=1700000000123;user-id=42 :alice!alice@alice.tmi.twitch.tv PRIVMSG #kneissbot :hello world`,
+			want: &PrivMsg{
+				Base: Base{Channel: "kneissbot", SentAt: sentAt},
+				User: User{
+					Badges:      map[string]string{"broadcaster": "1", "subscriber": "12"},
+					Color:       "#FF0000",
+					DisplayName: "Alice",
+					Login:       "alice",
+					Moderator:   true,
+					Subscriber:  true,
+					UserID:      "42",
+				},
+				Bits:          100,
+				ID:            "abc",
+				ReplyParentID: "def",
+				Text:          "hello world",
+			},
+		},
+		{
+			name:  "action",
+			input: ":alice!alice@alice.tmi.twitch.tv PRIVMSG #kneissbot :\x01ACTION waves\x01",
+			want: &PrivMsg{
+				Base:   Base{Channel: "kneissbot"},
+				User:   User{Badges: map[string]string{}, Login: "alice"},
+				Action: true,
+				Text:   "waves",
+			},
+		},
+		{
+			name:  "unterminated action",
+			input: ":alice!alice@alice.tmi.twitch.tv PRIVMSG #kneissbot :\x01ACTION waves",
+			want: &PrivMsg{
+				Base: Base{Channel: "kneissbot"},
+				User: User{Badges: map[string]string{}, Login: "alice"},
+				Text: "\x01ACTION waves",
+			},
+		},
+		{
+			name:  "timeout",
+			input: "@ban-duration=600;target-user-id=42;tmi-sent-ts=1700000000123 :tmi.twitch.tv CLEARCHAT #kneissbot :spammer",
+			want: &ClearChat{
+				Base:         Base{Channel: "kneissbot", SentAt: sentAt},
+				BanDuration:  10 * time.Minute,
+				Login:        "spammer",
+				TargetUserID: "42",
+			},
+		},
+		{
+			name:  "ban",
+			input: "@target-user-id=42 :tmi.twitch.tv CLEARCHAT #kneissbot :spammer",
+			want: &ClearChat{
+				Base:         Base{Channel: "kneissbot"},
+				Login:        "spammer",
+				TargetUserID: "42",
+			},
+		},
+		{
+			name:  "clear chat",
+			input: ":tmi.twitch.tv CLEARCHAT #kneissbot",
+			want:  &ClearChat{Base: Base{Channel: "kneissbot"}},
+		},
+		{
+			name:  "deleted message",
+			input: "@login=spammer;target-msg-id=abc :tmi.twitch.tv CLEARMSG #kneissbot :buy followers",
+			want: &ClearMsg{
+				Base:        Base{Channel: "kneissbot"},
+				Login:       "spammer",
+				TargetMsgID: "abc",
+				Text:        "buy followers",
+			},
+		},
+		{
+			name:  "resub",
+			input: `@badges=;id=abc;login=alice;msg-id=resub;msg-param-cumulative-months=12;msg-param-streak-months=3;msg-param-sub-plan=1000;system-msg=alice\ssubscribed :tmi.twitch.tv USERNOTICE #kneissbot :hello world`,
+			want: &UserNotice{
+				Base:             Base{Channel: "kneissbot"},
+				User:             User{Badges: map[string]string{}, Login: "alice"},
+				CumulativeMonths: 12,
+				ID:               "abc",
+				MsgID:            ResubNotice,
+				StreakMonths:     3,
+				SubPlan:          "1000",
+				SystemMsg:        "alice subscribed",
+				Text:             "hello world",
+			},
+		},
+		{
+			name:  "raid",
+			input: "@login=raider;msg-id=raid;msg-param-viewerCount=25 :tmi.twitch.tv USERNOTICE #kneissbot",
+			want: &UserNotice{
+				Base:    Base{Channel: "kneissbot"},
+				User:    User{Badges: map[string]string{}, Login: "raider"},
+				MsgID:   RaidNotice,
+				Viewers: 25,
+			},
+		},
+		{
+			name:  "room state",
+			input: "@emote-only=1;followers-only=-1;r9k=0;room-id=42;slow=30;subs-only=1 :tmi.twitch.tv ROOMSTATE #kneissbot",
+			want: &RoomState{
+				Base:          Base{Channel: "kneissbot"},
+				EmoteOnly:     true,
+				FollowersOnly: -time.Minute,
+				RoomID:        "42",
+				Slow:          30 * time.Second,
+				SubsOnly:      true,
+			},
+		},
+		{
+			name:  "user state",
+			input: "@badges=moderator/1;emote-sets=0,33;mod=1 :tmi.twitch.tv USERSTATE #kneissbot",
+			want: &UserState{
+				Base:      Base{Channel: "kneissbot"},
+				User:      User{Badges: map[string]string{"moderator": "1"}, Login: "tmi.twitch.tv", Moderator: true},
+				EmoteSets: []string{"0", "33"},
+			},
+		},
+		{
+			name:  "notice",
+			input: "@msg-id=msg_banned :tmi.twitch.tv NOTICE #kneissbot :You are permanently banned from talking in kneissbot.",
+			want: &Notice{
+				Base:  Base{Channel: "kneissbot"},
+				MsgID: "msg_banned",
+				Text:  "You are permanently banned from talking in kneissbot.",
+			},
+		},
+		{
+			name:  "host",
+			input: ":tmi.twitch.tv HOSTTARGET #kneissbot :other 10",
+			want:  &HostTarget{Base: Base{Channel: "kneissbot"}, Target: "other", Viewers: 10},
+		},
+		{
+			name:  "host stopped",
+			input: ":tmi.twitch.tv HOSTTARGET #kneissbot :- 0",
+			want:  &HostTarget{Base: Base{Channel: "kneissbot"}},
+		},
+		{
+			name:  "join",
+			input: ":alice!alice@alice.tmi.twitch.tv JOIN #kneissbot",
+			want:  &Join{Base: Base{Channel: "kneissbot"}, Login: "alice"},
+		},
+		{
+			name:  "part",
+			input: ":alice!alice@alice.tmi.twitch.tv PART #kneissbot",
+			want:  &Part{Base: Base{Channel: "kneissbot"}, Login: "alice"},
+		},
+		{
+			name:  "reconnect",
+			input: ":tmi.twitch.tv RECONNECT",
+			want:  &Reconnect{},
+		},
+		{
+			name:  "malformed numbers",
+			input: "@ban-duration=soon;tmi-sent-ts=yesterday :tmi.twitch.tv CLEARCHAT #kneissbot :spammer",
+			want:  &ClearChat{Base: Base{Channel: "kneissbot"}, Login: "spammer"},
+		},
+	}
+
+	for _, test := range tests {
+		message, err := irc.MakeMessage(test.input)
+
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		got, err := Parse(message)
+
+		if err != nil {
+			t.Errorf("%v: Parse returned %v", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got.Raw(), message) {
+			t.Errorf("%v: Raw() = %q", test.name, got.Raw().String())
+		}
+
+		// The decoded fields are compared apart from the raw message.
+		reflect.ValueOf(got).Elem().FieldByName("Message").Set(reflect.ValueOf(irc.Message{}))
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: Parse returned\n%+v\nwant\n%+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseUnsupported(t *testing.T) {
+	if _, err := Parse(irc.Message{Command: "PING", Params: []string{"tmi.twitch.tv"}}); err != ErrUnsupportedCommand {
+		t.Errorf("Parse returned %v, want %v", err, ErrUnsupportedCommand)
+	}
+}
+
+func TestClearChatPermanent(t *testing.T) {
+	tests := []struct {
+		event ClearChat
+		want  bool
+	}{
+		{ClearChat{Login: "spammer"}, true},
+		{ClearChat{BanDuration: time.Second, Login: "spammer"}, false},
+		{ClearChat{}, false},
+	}
+
+	for _, test := range tests {
+		if got := test.event.Permanent(); got != test.want {
+			t.Errorf("%+v.Permanent() = %v, want %v", test.event, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package tmi
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Ellipsis marks the end of a response which exceeded MaxChunks.
+const Ellipsis = "…"
+
+var (
+	// MaxChunks is the number of messages a single response may be split into.
+	MaxChunks = 3
+	// MaxMessageLength is the number of characters Twitch accepts in a chat message.
+	MaxMessageLength = 500
+)
+
+// Split splits text at word boundaries into chunks which, including the
+// prefix prepended to each of them, do not exceed MaxMessageLength
+// characters. Words longer than a chunk are split at rune boundaries.
+// Text beyond MaxChunks is dropped and the last chunk ends with Ellipsis.
+func Split(prefix, text string) []string {
+	limit := MaxMessageLength - utf8.RuneCountInString(prefix)
+
+	if limit <= utf8.RuneCountInString(Ellipsis) || MaxChunks < 1 {
+		return nil
+	}
+
+	chunks := make([]string, 0, 1)
+	chunk := make([]rune, 0, limit)
+
+	for _, word := range strings.Fields(text) {
+		runes := []rune(word)
+
+		if len(chunk) > 0 && len(chunk)+1+len(runes) <= limit {
+			chunk = append(chunk, ' ')
+			chunk = append(chunk, runes...)
+			continue
+		}
+
+		for len(runes) > 0 {
+			if len(chunk) > 0 {
+				chunks = append(chunks, prefix+string(chunk))
+				chunk = chunk[:0]
+			}
+
+			if len(chunks) == MaxChunks {
+				return truncate(chunks, prefix, limit)
+			}
+
+			n := len(runes)
+
+			if n > limit {
+				n = limit
+			}
+
+			chunk = append(chunk, runes[:n]...)
+			runes = runes[n:]
+		}
+	}
+
+	if len(chunk) > 0 {
+		chunks = append(chunks, prefix+string(chunk))
+	}
+
+	return chunks
+}
+
+// truncate replaces the end of the last chunk with Ellipsis.
+func truncate(chunks []string, prefix string, limit int) []string {
+	last := []rune(strings.TrimPrefix(chunks[len(chunks)-1], prefix))
+
+	if n := limit - utf8.RuneCountInString(Ellipsis); len(last) > n {
+		last = last[:n]
+	}
+
+	chunks[len(chunks)-1] = prefix + string(last) + Ellipsis
+	return chunks
+}
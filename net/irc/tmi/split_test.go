@@ -0,0 +1,44 @@
+package tmi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	length, chunks := MaxMessageLength, MaxChunks
+	MaxMessageLength, MaxChunks = 10, 3
+	defer func() { MaxMessageLength, MaxChunks = length, chunks }()
+
+	tests := []struct {
+		name   string
+		prefix string
+		text   string
+		want   []string
+	}{
+		{"short", "", "hello world", []string{"hello", "world"}},
+		{"fits", "", "hi PRIVMSG", []string{"hi PRIVMSG"}},
+		{"multi-byte runes", "", "héllo wörld ünïcode", []string{"héllo", "wörld", "ünïcode"}},
+		{"long word", "", "abcdefghijklmnopqrstuvwxyz", []string{"abcdefghij", "klmnopqrst", "uvwxyz"}},
+		{"long multi-byte word", "", "ääääääääääöö", []string{"ääääääääää", "öö"}},
+		{"ellipsis", "", "aaaa bbbb cccc dddd eeee ffff gggg", []string{"aaaa bbbb", "cccc dddd", "eeee ffff…"}},
+		{"ellipsis in word", "", "abcdefghijklmnopqrstuvwxyz0123456789", []string{"abcdefghij", "klmnopqrst", "uvwxyz012…"}},
+		{"whisper", "/w bob ", "hi you", []string{"/w bob hi", "/w bob you"}},
+		{"no room", "/w somebody ", "hi", nil},
+		{"line breaks", "", "hi\r\nPRIVMSG", []string{"hi PRIVMSG"}},
+		{"whitespace", "", " \t\r\n ", nil},
+		{"empty", "", "", nil},
+	}
+
+	for _, test := range tests {
+		got := Split(test.prefix, test.text)
+
+		if len(got) == 0 && len(test.want) == 0 {
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: Split(%q, %q) = %q, want %q", test.name, test.prefix, test.text, got, test.want)
+		}
+	}
+}
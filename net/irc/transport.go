@@ -0,0 +1,104 @@
+package irc
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// DialTimeout is the maximum amount of time a TCP or TLS dial waits for a
+// connection to be established.
+var DialTimeout = 30 * time.Second
+
+// Transport establishes connections to an IRC server. A Session dials its
+// Transport when it is created and again when reconnecting.
+type Transport interface {
+	Dial() (io.ReadWriteCloser, error)
+}
+
+// WebsocketTransport connects to an IRC server over a websocket.
+// Each write is sent as a single websocket frame.
+type WebsocketTransport struct {
+	Origin string
+	URL    string
+}
+
+// Dial opens a websocket connection to the URL.
+func (wt *WebsocketTransport) Dial() (io.ReadWriteCloser, error) {
+	return websocket.Dial(wt.URL, "", wt.Origin)
+}
+
+// TCPTransport connects to an IRC server over a plain TCP connection.
+type TCPTransport struct {
+	Address string
+}
+
+// Dial opens a TCP connection to the address.
+func (tt *TCPTransport) Dial() (io.ReadWriteCloser, error) {
+	return net.DialTimeout("tcp", tt.Address, DialTimeout)
+}
+
+// TLSTransport connects to an IRC server over TLS. A nil Config uses the
+// default configuration with the server name taken from the address.
+type TLSTransport struct {
+	Address string
+	Config  *tls.Config
+}
+
+// Dial opens a TLS connection to the address.
+func (tt *TLSTransport) Dial() (io.ReadWriteCloser, error) {
+	dialer := &net.Dialer{Timeout: DialTimeout}
+	return tls.DialWithDialer(dialer, "tcp", tt.Address, tt.Config)
+}
+
+// DiscardTransport connects to nowhere. Writes are discarded and reads
+// block until the connection is closed. It is used when replaying.
+type DiscardTransport struct{}
+
+// Dial returns a connection which discards writes.
+func (DiscardTransport) Dial() (io.ReadWriteCloser, error) {
+	return &discardConn{closed: make(chan struct{})}, nil
+}
+
+type discardConn struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (dc *discardConn) Close() error {
+	dc.once.Do(func() { close(dc.closed) })
+	return nil
+}
+
+func (dc *discardConn) Read(p []byte) (int, error) {
+	<-dc.closed
+	return 0, io.EOF
+}
+
+func (dc *discardConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// PipeTransport connects to an in-memory IRC server. Every dial creates a
+// synchronous pipe and delivers the server end on Server.
+type PipeTransport struct {
+	Server chan net.Conn
+}
+
+// NewPipeTransport creates and initializes a PipeTransport.
+func NewPipeTransport() *PipeTransport {
+	return &PipeTransport{
+		Server: make(chan net.Conn, 1),
+	}
+}
+
+// Dial creates a pipe and returns the client end.
+func (pt *PipeTransport) Dial() (io.ReadWriteCloser, error) {
+	client, server := net.Pipe()
+	pt.Server <- server
+	return client, nil
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/twitch"
+)
+
+const (
+	// DeviceURL is the endpoint for starting a device authorization
+	DeviceURL = "https://id.twitch.tv/oauth2/device"
+	// DeviceGrantType is the grant type for exchanging a device code
+	DeviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+var (
+	// ErrDeviceCodeExpired is returned when the user did not authorize the
+	// device before the device code expired.
+	ErrDeviceCodeExpired = errors.New("Device code expired")
+)
+
+// DeviceCode is the JSON structure returned by Twitch when starting a
+// device authorization.
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+}
+
+// DeviceToken is the JSON structure returned by Twitch when polling for
+// the token of a device authorization. Twitch reports pending or failed
+// authorizations in Message rather than the error field of RFC 8628.
+type DeviceToken struct {
+	AccessToken  string `json:"access_token"`
+	Error        string `json:"error"`
+	ExpiresIn    int    `json:"expires_in"`
+	Message      string `json:"message"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+}
+
+// DeviceAuth contains variables needed to authorize the app on a device
+// without a browser, such as a headless server. Sleep waits between polls
+// and defaults to time.Sleep.
+type DeviceAuth struct {
+	Client    *http.Client
+	DeviceURL string
+	Output    io.Writer
+	Sleep     func(time.Duration)
+	TokenURL  string
+}
+
+// NewDeviceAuth creates and initializes a DeviceAuth printing instructions
+// for the user to the given output.
+func NewDeviceAuth(output io.Writer) *DeviceAuth {
+	return &DeviceAuth{
+		Client:    new(http.Client),
+		DeviceURL: DeviceURL,
+		Output:    output,
+		Sleep:     time.Sleep,
+		TokenURL:  twitch.Endpoint.TokenURL,
+	}
+}
+
+// Authenticate requests a device code, prints the verification URL and
+// user code and blocks until the user authorized the device.
+func (da *DeviceAuth) Authenticate() (*oauth2.Token, error) {
+	code, err := da.Code()
+
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(da.Output, "Visit %v and enter the code %v\n", code.VerificationURI, code.UserCode)
+	return da.Poll(code)
+}
+
+// Code requests a device code for the scopes of the app.
+func (da *DeviceAuth) Code() (*DeviceCode, error) {
+	query := make(url.Values)
+	query.Add("client_id", ClientID)
+	query.Add("scopes", strings.Join(Scopes, " "))
+	resp, err := da.Client.PostForm(da.DeviceURL, query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = resp.Body.Close(); err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Unable to retrieve device code - " + resp.Status)
+	}
+
+	code := new(DeviceCode)
+
+	if err = json.Unmarshal(body, code); err != nil {
+		return nil, err
+	}
+
+	return code, nil
+}
+
+// Poll requests the token at the interval given by the device code until
+// the user authorized the device, denied it or the device code expired.
+func (da *DeviceAuth) Poll(code *DeviceCode) (*oauth2.Token, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	sleep := da.Sleep
+
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	query := make(url.Values)
+	query.Add("client_id", ClientID)
+	query.Add("device_code", code.DeviceCode)
+	query.Add("grant_type", DeviceGrantType)
+	query.Add("scopes", strings.Join(Scopes, " "))
+
+	for time.Now().Before(deadline) {
+		sleep(interval)
+		resp, err := da.Client.PostForm(da.TokenURL, query)
+
+		if err != nil {
+			return nil, err
+		}
+
+		token := new(DeviceToken)
+		err = json.NewDecoder(resp.Body).Decode(token)
+
+		if closeErr := resp.Body.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			return &oauth2.Token{
+				AccessToken:  token.AccessToken,
+				Expiry:       time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+				RefreshToken: token.RefreshToken,
+				TokenType:    token.TokenType,
+			}, nil
+		}
+
+		switch reason := token.Reason(); reason {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "expired_token":
+			return nil, ErrDeviceCodeExpired
+		default:
+			return nil, errors.New("Device authorization failed - " + reason)
+		}
+	}
+
+	return nil, ErrDeviceCodeExpired
+}
+
+// Reason returns the RFC 8628 error code of a failed poll, found in either
+// Message or Error.
+func (dt *DeviceToken) Reason() string {
+	for _, reason := range []string{dt.Message, dt.Error} {
+		switch reason {
+		case "access_denied", "authorization_pending", "expired_token", "slow_down":
+			return reason
+		}
+	}
+
+	if len(dt.Message) > 0 {
+		return dt.Message
+	}
+
+	return dt.Error
+}
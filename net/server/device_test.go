@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newDeviceAuth returns a DeviceAuth polling the given token endpoint and
+// recording the time it waits instead of sleeping.
+func newDeviceAuth(tokenURL string, slept *[]time.Duration) *DeviceAuth {
+	da := NewDeviceAuth(ioutil.Discard)
+	da.TokenURL = tokenURL
+	da.Sleep = func(d time.Duration) { *slept = append(*slept, d) }
+	return da
+}
+
+func TestDeviceAuthPoll(t *testing.T) {
+	replies := []struct {
+		status int
+		body   DeviceToken
+	}{
+		{http.StatusBadRequest, DeviceToken{Message: "authorization_pending"}},
+		{http.StatusBadRequest, DeviceToken{Message: "slow_down"}},
+		{http.StatusBadRequest, DeviceToken{Message: "authorization_pending"}},
+		{http.StatusOK, DeviceToken{AccessToken: "access", ExpiresIn: 3600, RefreshToken: "refresh", TokenType: "bearer"}},
+	}
+
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+
+		if r.Form.Get("device_code") != "device" || r.Form.Get("grant_type") != DeviceGrantType || r.Form.Get("client_id") != ClientID {
+			t.Errorf("unexpected form %v", r.Form)
+		}
+
+		reply := replies[polls]
+		polls++
+		w.WriteHeader(reply.status)
+		json.NewEncoder(w).Encode(reply.body)
+	}))
+	defer server.Close()
+
+	var slept []time.Duration
+	da := newDeviceAuth(server.URL, &slept)
+	token, err := da.Poll(&DeviceCode{DeviceCode: "device", ExpiresIn: 1800, Interval: 2})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token.AccessToken != "access" || token.RefreshToken != "refresh" || token.Expiry.Before(time.Now().Add(59*time.Minute)) {
+		t.Errorf("unexpected token %+v", token)
+	}
+
+	want := []time.Duration{2 * time.Second, 2 * time.Second, 7 * time.Second, 7 * time.Second}
+
+	if !reflect.DeepEqual(slept, want) {
+		t.Errorf("slept %v, want %v", slept, want)
+	}
+}
+
+func TestDeviceAuthPollFailure(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   string
+	}{
+		{"expired_token", ErrDeviceCodeExpired.Error()},
+		{"access_denied", "access_denied"},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(DeviceToken{Error: "invalid_request", Message: test.reason})
+		}))
+
+		var slept []time.Duration
+		_, err := newDeviceAuth(server.URL, &slept).Poll(&DeviceCode{DeviceCode: "device", ExpiresIn: 1800})
+		server.Close()
+
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Poll with %v returned error %v, want %v", test.reason, err, test.want)
+		}
+
+		if len(slept) != 1 || slept[0] != 5*time.Second {
+			t.Errorf("Poll with %v slept %v, want the default interval once", test.reason, slept)
+		}
+	}
+}
@@ -1,17 +1,17 @@
 package server
 
 import (
-	"bytes"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
+	"os"
 	"strings"
 
 	"github.com/kookehs/kneissbot/os/exec"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/twitch"
 )
 
@@ -20,30 +20,50 @@ const (
 	ClientID = "2qt0hvdtidd4o2p7r0ndjajnawb080"
 	// RedirectURI should match the URL entered when registering app on Twitch
 	RedirectURI = "http://localhost:8080/twitch"
-	// ResponseType must be token for OAuth 2 Implicit Code Flow
-	ResponseType = "token"
 )
 
 var (
-	// Scopes based on requirements of the app
-	Scopes = []string{"channel_check_subscription", "channel_subscriptions", "chat_login", "communities_moderate"}
+	// ClientSecret is provided by Twitch and read from the environment as
+	// it must not be distributed with the app.
+	ClientSecret = os.Getenv("KNEISSBOT_CLIENT_SECRET")
+	// Scopes based on requirements of the app. The chat scopes are needed
+	// to log into IRC and send messages.
+	Scopes = []string{
+		"channel:manage:moderators",
+		"channel:manage:vips",
+		"channel:moderate",
+		"channel:read:redemptions",
+		"channel:read:subscriptions",
+		"chat:edit",
+		"chat:read",
+		"moderation:read",
+		"moderator:manage:banned_users",
+		"moderator:manage:chat_messages",
+		"moderator:read:chatters",
+		"user:read:chat",
+	}
 )
 
 // TwitchAuth contains variables need to set up an OAuth 2 connection
-// with the Twitch API.
+// with the Twitch API. The retrieved token is sent to Channel, while a
+// denied authorization or failed exchange is sent to Errors.
 type TwitchAuth struct {
-	Channel chan string
-	Server  *http.Server
-	State   string
+	Channel  chan *oauth2.Token
+	Errors   chan error
+	Server   *http.Server
+	State    string
+	TokenURL string
+	Verifier string
 }
 
 // NewTwitchAuth creates and initializes a local server used to
-// authorize an OAuth Implicit Code flow.
-func NewTwitchAuth(channel chan string) *TwitchAuth {
+// authorize an OAuth Authorization Code flow.
+func NewTwitchAuth(channel chan *oauth2.Token) *TwitchAuth {
 	twitchAuth := new(TwitchAuth)
 	twitchAuth.Channel = channel
+	twitchAuth.Errors = make(chan error, 1)
+	twitchAuth.TokenURL = twitch.Endpoint.TokenURL
 	serveMux := http.NewServeMux()
-	serveMux.HandleFunc("/token", twitchAuth.TokenRetrieval)
 	serveMux.HandleFunc("/twitch", twitchAuth.TwitchAuthorization)
 	server := new(http.Server)
 	server.Addr = ":8080"
@@ -52,8 +72,19 @@ func NewTwitchAuth(channel chan string) *TwitchAuth {
 	return twitchAuth
 }
 
-// Authenticate generates a state key and redirects the user to
-// Twitch's authorization page.
+// OAuth2Config returns the configuration used to exchange and refresh tokens.
+func OAuth2Config() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     ClientID,
+		ClientSecret: ClientSecret,
+		Endpoint:     twitch.Endpoint,
+		RedirectURL:  RedirectURI,
+		Scopes:       Scopes,
+	}
+}
+
+// Authenticate generates a state key and a PKCE verifier and redirects
+// the user to Twitch's authorization page.
 func (ta *TwitchAuth) Authenticate() error {
 	key := make([]byte, 64)
 
@@ -61,33 +92,13 @@ func (ta *TwitchAuth) Authenticate() error {
 		return err
 	}
 
-	ta.State = base64.StdEncoding.EncodeToString(key)
-	query := ta.BuildQuery()
-	href := twitch.Endpoint.AuthURL + "?" + query.Encode()
+	ta.State = base64.RawURLEncoding.EncodeToString(key)
+	ta.Verifier = oauth2.GenerateVerifier()
+	config := OAuth2Config()
+	href := config.AuthCodeURL(ta.State, oauth2.S256ChallengeOption(ta.Verifier))
 	return ta.RedirectToURL(href)
 }
 
-// BuildQuery constructs the query part of the URL
-func (ta *TwitchAuth) BuildQuery() url.Values {
-	var scopes bytes.Buffer
-
-	for i, v := range Scopes {
-		if i != 0 {
-			scopes.WriteByte(' ')
-		}
-
-		scopes.WriteString(v)
-	}
-
-	query := make(url.Values)
-	query.Add("client_id", ClientID)
-	query.Add("redirect_uri", RedirectURI)
-	query.Add("response_type", ResponseType)
-	query.Add("scope", scopes.String())
-	query.Add("state", url.QueryEscape(ta.State))
-	return query
-}
-
 // Close forcefully shuts down the underlying server.
 func (ta *TwitchAuth) Close() error {
 	return ta.Server.Close()
@@ -104,44 +115,44 @@ func (ta *TwitchAuth) RedirectToURL(url string) error {
 	return exec.OpenBrowser(url)
 }
 
-// TokenRetrieval checks the state variable and extracts the
-// access token from the URL.
-func (ta *TwitchAuth) TokenRetrieval(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
+// TwitchAuthorization handles the Twitch redirect by checking the state
+// variable and exchanging the authorization code for a token. Requests
+// with an invalid state are ignored, any other failure is sent to Errors.
+func (ta *TwitchAuth) TwitchAuthorization(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
 
-	if err != nil {
-		log.Println(err)
+	if strings.Compare(ta.State, query.Get("state")) != 0 {
+		http.Error(w, "Invalid state", http.StatusBadRequest)
 		return
 	}
 
-	if err = r.Body.Close(); err != nil {
-		log.Println(err)
+	if reason := query.Get("error"); len(reason) > 0 {
+		log.Println("[Bot]: Authorization denied - " + query.Get("error_description"))
+		http.Error(w, reason, http.StatusUnauthorized)
+		ta.fail(errors.New("Authorization denied - " + reason))
 		return
 	}
 
-	href, err := url.Parse(string(body[1 : len(body)-1]))
+	config := OAuth2Config()
+	config.Endpoint.TokenURL = ta.TokenURL
+	token, err := config.Exchange(r.Context(), query.Get("code"), oauth2.VerifierOption(ta.Verifier))
 
 	if err != nil {
-		log.Println(err)
+		http.Error(w, "Unable to retrieve token", http.StatusBadGateway)
+		ta.fail(err)
 		return
 	}
 
-	split := strings.IndexByte(href.Fragment, '&')
-	fragment := href.Fragment[:split]
-	query, err := url.ParseQuery(href.Fragment[split:])
+	http.ServeFile(w, r, "views/twitch.html")
+	ta.Channel <- token
+}
 
-	if err != nil {
+// fail sends the error to Errors unless an earlier error is still pending,
+// so that repeated redirects do not block the handler.
+func (ta *TwitchAuth) fail(err error) {
+	select {
+	case ta.Errors <- err:
+	default:
 		log.Println(err)
-		return
 	}
-
-	if strings.Compare(ta.State, query["state"][0]) == 0 {
-		token := fragment[strings.IndexByte(fragment, '=')+1:]
-		ta.Channel <- token
-	}
-}
-
-// TwitchAuthorization handles the Twitch redirect by serving a HTML file.
-func (ta *TwitchAuth) TwitchAuthorization(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "views/twitch.html")
 }
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestTwitchAuthorizationFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"status":400,"message":"Invalid authorization code"}`, http.StatusBadRequest)
+	}))
+
+	defer server.Close()
+	ta := NewTwitchAuth(make(chan *oauth2.Token))
+	ta.State = "state"
+	ta.TokenURL = server.URL
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"?state=state&code=code", http.StatusBadGateway},
+		{"?state=state&error=access_denied&error_description=denied", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		ta.TwitchAuthorization(recorder, httptest.NewRequest("GET", "/twitch"+test.query, nil))
+
+		if recorder.Code != test.status {
+			t.Errorf("%v: status %v, want %v", test.query, recorder.Code, test.status)
+		}
+
+		select {
+		case err := <-ta.Errors:
+			if err == nil {
+				t.Errorf("%v: nil error sent", test.query)
+			}
+		default:
+			t.Errorf("%v: no error sent", test.query)
+		}
+	}
+
+	// Requests with an invalid state do not fail the authorization.
+	recorder := httptest.NewRecorder()
+	ta.TwitchAuthorization(recorder, httptest.NewRequest("GET", "/twitch?state=other&code=code", nil))
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Invalid state: status %v", recorder.Code)
+	}
+
+	select {
+	case err := <-ta.Errors:
+		t.Errorf("Invalid state sent %v", err)
+	default:
+	}
+}
@@ -0,0 +1,125 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and schedules functions. Code which depends on the
+// passing of time takes a Clock so that it can be driven by a Fake.
+type Clock interface {
+	AfterFunc(d time.Duration, f func()) Timer
+	Now() time.Time
+}
+
+// Timer is a scheduled function which may be cancelled.
+type Timer interface {
+	Stop() bool
+}
+
+// Real is a Clock backed by the time package.
+type Real struct{}
+
+// AfterFunc calls f in its own goroutine after the duration elapses.
+func (Real) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// Now returns the current local time.
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// Fake is a Clock which only moves when told to. Scheduled functions are
+// called synchronously by Advance and Set in the order of their deadlines.
+type Fake struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFake creates and initializes a Fake set to the given time.
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		now: now,
+	}
+}
+
+// AfterFunc schedules f to be called once the clock has advanced by d.
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	timer := &fakeTimer{
+		clock:    f,
+		deadline: f.now.Add(d),
+		fn:       fn,
+	}
+	f.timers = append(f.timers, timer)
+	return timer
+}
+
+// Advance moves the clock forward by d.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Now returns the current time of the clock.
+func (f *Fake) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+// Set moves the clock to the given time and calls every function whose
+// deadline has passed. While a function runs the clock reads its deadline.
+// Setting the clock backwards does not call any function.
+func (f *Fake) Set(t time.Time) {
+	for {
+		f.mutex.Lock()
+		next := -1
+
+		for i, timer := range f.timers {
+			if !timer.deadline.After(t) && (next == -1 || timer.deadline.Before(f.timers[next].deadline)) {
+				next = i
+			}
+		}
+
+		if next == -1 {
+			f.now = t
+			f.mutex.Unlock()
+			return
+		}
+
+		timer := f.timers[next]
+		f.timers = append(f.timers[:next], f.timers[next+1:]...)
+
+		if timer.deadline.After(f.now) {
+			f.now = timer.deadline
+		}
+
+		f.mutex.Unlock()
+		timer.fn()
+	}
+}
+
+// fakeTimer is a function scheduled on a Fake.
+type fakeTimer struct {
+	clock    *Fake
+	deadline time.Time
+	fn       func()
+}
+
+// Stop cancels the timer and returns whether it was still scheduled.
+func (ft *fakeTimer) Stop() bool {
+	ft.clock.mutex.Lock()
+	defer ft.clock.mutex.Unlock()
+
+	for i, timer := range ft.clock.timers {
+		if timer == ft {
+			ft.clock.timers = append(ft.clock.timers[:i], ft.clock.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,69 @@
+package clock
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFakeOrder(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	fake := NewFake(start)
+	fired := make([]time.Duration, 0)
+	record := func() {
+		fired = append(fired, fake.Now().Sub(start))
+	}
+
+	fake.AfterFunc(3*time.Second, record)
+	fake.AfterFunc(time.Second, func() {
+		record()
+
+		// Timers scheduled while setting fire if their deadline passed.
+		fake.AfterFunc(time.Second, record)
+	})
+	fake.AfterFunc(2*time.Second, record)
+	fake.AfterFunc(10*time.Second, record)
+	fake.Set(start.Add(5 * time.Second))
+
+	// Timers fire in deadline order and read their deadline as the time.
+	want := []time.Duration{time.Second, 2 * time.Second, 2 * time.Second, 3 * time.Second}
+
+	if !reflect.DeepEqual(fired, want) {
+		t.Errorf("Timers fired at %v, want %v", fired, want)
+	}
+
+	if got := fake.Now(); !got.Equal(start.Add(5 * time.Second)) {
+		t.Errorf("Now() = %v after setting the clock", got)
+	}
+
+	fake.Advance(5 * time.Second)
+
+	if len(fired) != 5 || fired[4] != 10*time.Second {
+		t.Errorf("Timers fired at %v after advancing", fired)
+	}
+}
+
+func TestFakeStop(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	fake := NewFake(start)
+	fired := false
+	timer := fake.AfterFunc(time.Second, func() { fired = true })
+
+	if !timer.Stop() {
+		t.Error("Stop returned false for a scheduled timer")
+	}
+
+	fake.Advance(time.Second)
+
+	if fired || timer.Stop() {
+		t.Error("Stopped timer fired or was still scheduled")
+	}
+
+	// Setting the clock backwards does not fire timers.
+	fake.AfterFunc(time.Second, func() { fired = true })
+	fake.Set(start)
+
+	if fired || !fake.Now().Equal(start) {
+		t.Errorf("Setting the clock backwards fired %v and reads %v", fired, fake.Now())
+	}
+}